@@ -0,0 +1,212 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/CyCoreSystems/dispatchers/v2"
+	"github.com/CyCoreSystems/dispatchers/v2/sets"
+)
+
+// maxAdminRequestSize is the maximum size of the body of an administrative request.
+const maxAdminRequestSize = 64 * 1024
+
+// apiOverrideRequest is the body of an administrative request which overrides a member of a dispatcher set.
+type apiOverrideRequest struct {
+	// Address and Port identify the member to add.  They are only used when adding a member.
+	Address string `json:"address"`
+	Port    uint32 `json:"port"`
+
+	// TTL is the optional duration, such as "15m", after which the override expires.
+	TTL string `json:"ttl"`
+
+	// Reason is an optional description of the reason for the override.
+	Reason string `json:"reason"`
+}
+
+// Serve the administrative requests of version 1 of the REST API.
+// All administrative requests require scopeAdmin.
+// URL:  POST /api/v1/sets/<setID>/endpoints/<ip>[:port]/disable
+// URL:  POST /api/v1/sets/<setID>/endpoints/<ip>[:port]/drain
+// URL:  POST /api/v1/sets/<setID>/endpoints/<ip>[:port]/enable
+// URL:  POST /api/v1/sets/<setID>/endpoints
+// URL:  DELETE /api/v1/sets/<setID>/endpoints/<ip>[:port]
+// URL:  POST /api/v1/export
+// URL:  POST /api/v1/notify
+func (h *Handler) handleAPIAdminRequest(w http.ResponseWriter, r *http.Request, pieces []string) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		writeAPIError(w, http.StatusMethodNotAllowed, "method %s is not allowed", r.Method)
+		return
+	}
+
+	if !h.authorize(w, r, scopeAdmin) {
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && len(pieces) == 1 && pieces[0] == "export":
+		h.handleAPIProcess(w, h.Controller.Export)
+	case r.Method == http.MethodPost && len(pieces) == 1 && pieces[0] == "notify":
+		h.handleAPIProcess(w, h.Controller.Notify)
+	case r.Method == http.MethodPost && len(pieces) == 3 && pieces[0] == "sets" && pieces[2] == "endpoints":
+		h.handleAPIAddEndpoint(w, r, pieces[1])
+	case r.Method == http.MethodPost && len(pieces) == 5 && pieces[0] == "sets" && pieces[2] == "endpoints":
+		switch pieces[4] {
+		case "disable":
+			h.handleAPIOverrideEndpoint(w, r, pieces[1], pieces[3], dispatchers.OverrideDisable)
+		case "drain":
+			h.handleAPIOverrideEndpoint(w, r, pieces[1], pieces[3], dispatchers.OverrideDrain)
+		case "enable":
+			h.handleAPIRemoveOverride(w, pieces[1], pieces[3])
+		default:
+			writeAPIError(w, http.StatusNotFound, "no such action %q", pieces[4])
+		}
+	case r.Method == http.MethodDelete && len(pieces) == 4 && pieces[0] == "sets" && pieces[2] == "endpoints":
+		h.handleAPIRemoveOverride(w, pieces[1], pieces[3])
+	default:
+		writeAPIError(w, http.StatusNotFound, "no such resource %s %s", r.Method, r.URL.Path)
+	}
+}
+
+func (h *Handler) handleAPIOverrides(w http.ResponseWriter) {
+	out := []*dispatchers.Override{}
+
+	if h.Controller.Overrides != nil {
+		out = append(out, h.Controller.Overrides.List()...)
+	}
+
+	writeAPIResponse(w, http.StatusOK, out)
+}
+
+// handleAPIProcess forces an export or notification, responding with the resulting status of the Controller.
+func (h *Handler) handleAPIProcess(w http.ResponseWriter, process func() error) {
+	if err := process(); err != nil {
+		writeAPIError(w, http.StatusBadGateway, "%v", err)
+		return
+	}
+
+	h.handleAPIStatus(w)
+}
+
+func (h *Handler) handleAPIAddEndpoint(w http.ResponseWriter, r *http.Request, id string) {
+	set, ok := h.findAPISet(w, id)
+	if !ok {
+		return
+	}
+
+	req, ok := readOverrideRequest(w, r)
+	if !ok {
+		return
+	}
+
+	addr, port := req.Address, req.Port
+
+	if port == 0 {
+		var err error
+
+		if addr, port, err = parseAddressPort(req.Address); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "failed to parse address %q: %v", req.Address, err)
+			return
+		}
+	}
+
+	if ip, ok := sets.ParseIP(addr); ok {
+		addr = ip.String()
+	}
+
+	h.setOverride(w, req, &dispatchers.Override{
+		Set:     set.State().ID,
+		Address: addr,
+		Port:    port,
+		Action:  dispatchers.OverrideAdd,
+	})
+}
+
+func (h *Handler) handleAPIOverrideEndpoint(w http.ResponseWriter, r *http.Request, id string, target string, action dispatchers.OverrideAction) {
+	set, ok := h.findAPISet(w, id)
+	if !ok {
+		return
+	}
+
+	addr, port, err := parseAddressPort(target)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "failed to parse endpoint %q: %v", target, err)
+		return
+	}
+
+	req, ok := readOverrideRequest(w, r)
+	if !ok {
+		return
+	}
+
+	h.setOverride(w, req, &dispatchers.Override{
+		Set:     set.State().ID,
+		Address: addr,
+		Port:    port,
+		Action:  action,
+	})
+}
+
+func (h *Handler) setOverride(w http.ResponseWriter, req *apiOverrideRequest, ov *dispatchers.Override) {
+	ov.Reason = req.Reason
+
+	if req.TTL != "" {
+		ttl, err := time.ParseDuration(req.TTL)
+		if err != nil || ttl <= 0 {
+			writeAPIError(w, http.StatusBadRequest, "invalid TTL %q", req.TTL)
+			return
+		}
+
+		expires := time.Now().UTC().Add(ttl)
+		ov.Expires = &expires
+	}
+
+	if err := h.Controller.SetOverride(ov); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "failed to set override: %v", err)
+		return
+	}
+
+	writeAPIResponse(w, http.StatusOK, ov)
+}
+
+func (h *Handler) handleAPIRemoveOverride(w http.ResponseWriter, id string, target string) {
+	set, ok := h.findAPISet(w, id)
+	if !ok {
+		return
+	}
+
+	addr, port, err := parseAddressPort(target)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "failed to parse endpoint %q: %v", target, err)
+		return
+	}
+
+	removed, err := h.Controller.RemoveOverride(set.State().ID, addr, port)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "failed to remove override: %v", err)
+		return
+	}
+
+	if !removed {
+		writeAPIError(w, http.StatusNotFound, "endpoint %s of set %s has no override", target, id)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// readOverrideRequest reads the optional body of an override request, writing an error response if it is invalid.
+func readOverrideRequest(w http.ResponseWriter, r *http.Request) (*apiOverrideRequest, bool) {
+	req := new(apiOverrideRequest)
+
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminRequestSize)).Decode(req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		writeAPIError(w, http.StatusBadRequest, "failed to parse request: %v", err)
+		return nil, false
+	}
+
+	return req, true
+}
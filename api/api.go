@@ -0,0 +1,256 @@
+package api
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CyCoreSystems/dispatchers/v2"
+	"github.com/CyCoreSystems/dispatchers/v2/sets"
+)
+
+// apiPrefix is the path prefix of version 1 of the REST API.
+const apiPrefix = "/api/v1/"
+
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// apiSet describes a dispatcher set in the REST API.
+type apiSet struct {
+	ID        int            `json:"id"`
+	Version   uint64         `json:"version"`
+	Synced    bool           `json:"synced"`
+	Endpoints []*apiEndpoint `json:"endpoints"`
+}
+
+// apiEndpoint describes a member of a dispatcher set in the REST API.
+type apiEndpoint struct {
+	Address    string            `json:"address"`
+	Port       uint32            `json:"port"`
+	Ready      bool              `json:"ready"`
+	Drained    bool              `json:"drained,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// apiStatus describes the status of the Controller in the REST API.
+type apiStatus struct {
+	Revision uint64 `json:"revision"`
+	Synced   bool   `json:"synced"`
+	Ready    bool   `json:"ready"`
+
+	// Reason describes why the Controller is not ready.
+	Reason string `json:"reason,omitempty"`
+
+	Export *apiResult `json:"export"`
+	Notify *apiResult `json:"notify"`
+}
+
+// apiResult describes the most recent result of an export or notification in the REST API.
+type apiResult struct {
+	// Succeeded indicates that at least one attempt has succeeded.
+	Succeeded bool `json:"succeeded"`
+
+	// Time is the time of the most recent attempt, if any.
+	Time *time.Time `json:"time,omitempty"`
+
+	// Error is the error of the most recent attempt, if it failed.
+	Error string `json:"error,omitempty"`
+}
+
+// apiError is the body of all error responses of the REST API.
+type apiError struct {
+	Error apiErrorDetail `json:"error"`
+}
+
+type apiErrorDetail struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// newAPISet describes a dispatcher set of the Controller, with any overrides applied.
+func newAPISet(c *dispatchers.Controller, set sets.DispatcherSet) *apiSet {
+	state := c.Overrides.Apply(set.State().Copy(), nil)
+
+	out := &apiSet{
+		ID:        state.ID,
+		Version:   state.Version,
+		Synced:    sets.HasSynced(set),
+		Endpoints: []*apiEndpoint{},
+	}
+
+	for _, ep := range state.Endpoints {
+		out.Endpoints = append(out.Endpoints, newAPIEndpoint(ep))
+	}
+
+	return out
+}
+
+func newAPIEndpoint(ep *sets.Endpoint) *apiEndpoint {
+	return &apiEndpoint{
+		Address:    ep.Address,
+		Port:       ep.Port,
+		Ready:      !ep.NotReady,
+		Drained:    ep.Drained,
+		Attributes: ep.Copy().Attributes,
+	}
+}
+
+func newAPIResult(succeeded bool, t time.Time, err error) *apiResult {
+	out := &apiResult{
+		Succeeded: succeeded,
+	}
+
+	if !t.IsZero() {
+		out.Time = &t
+	}
+
+	if err != nil {
+		out.Error = err.Error()
+	}
+
+	return out
+}
+
+// Serve version 1 of the REST API.
+// URL:  /api/v1/sets
+// URL:  /api/v1/sets/<setID>
+// URL:  /api/v1/sets/<setID>/endpoints/<ip>[:port]
+// URL:  /api/v1/overrides
+// URL:  /api/v1/status
+// URL:  /api/v1/watch
+// URL:  /api/v1/openapi.json
+//
+// These requests require scopeRead.  Requests with other methods are administrative;  see handleAPIAdminRequest.
+func (h *Handler) handleAPIRequest(w http.ResponseWriter, r *http.Request) {
+	pieces := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, apiPrefix), "/"), "/")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		h.handleAPIAdminRequest(w, r, pieces)
+		return
+	}
+
+	if !h.authorize(w, r, scopeRead) {
+		return
+	}
+
+	switch {
+	case len(pieces) == 1 && pieces[0] == "openapi.json":
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openAPIDocument) // nolint: errcheck
+	case len(pieces) == 1 && pieces[0] == "status":
+		h.handleAPIStatus(w)
+	case len(pieces) == 1 && pieces[0] == "watch":
+		h.handleAPIWatch(w, r)
+	case len(pieces) == 1 && pieces[0] == "overrides":
+		h.handleAPIOverrides(w)
+	case len(pieces) == 1 && pieces[0] == "sets":
+		h.handleAPISets(w)
+	case len(pieces) == 2 && pieces[0] == "sets":
+		h.handleAPISet(w, pieces[1])
+	case len(pieces) == 4 && pieces[0] == "sets" && pieces[2] == "endpoints":
+		h.handleAPIEndpoint(w, pieces[1], pieces[3])
+	default:
+		writeAPIError(w, http.StatusNotFound, "no such resource %s", r.URL.Path)
+	}
+}
+
+func (h *Handler) handleAPIStatus(w http.ResponseWriter) {
+	status := h.Controller.Status()
+
+	out := &apiStatus{
+		Revision: h.Controller.Revision(),
+		Synced:   h.Controller.Synced(),
+		Ready:    true,
+		Export:   newAPIResult(status.Exported, status.LastExport, status.ExportError),
+		Notify:   newAPIResult(status.Notified, status.LastNotify, status.NotifyError),
+	}
+
+	if err := h.Controller.Ready(); err != nil {
+		out.Ready = false
+		out.Reason = err.Error()
+	}
+
+	writeAPIResponse(w, http.StatusOK, out)
+}
+
+func (h *Handler) handleAPISets(w http.ResponseWriter) {
+	out := []*apiSet{}
+
+	for _, set := range h.Controller.Sets() {
+		out = append(out, newAPISet(h.Controller, set))
+	}
+
+	writeAPIResponse(w, http.StatusOK, out)
+}
+
+func (h *Handler) handleAPISet(w http.ResponseWriter, id string) {
+	set, ok := h.findAPISet(w, id)
+	if !ok {
+		return
+	}
+
+	writeAPIResponse(w, http.StatusOK, newAPISet(h.Controller, set))
+}
+
+func (h *Handler) handleAPIEndpoint(w http.ResponseWriter, id string, target string) {
+	set, ok := h.findAPISet(w, id)
+	if !ok {
+		return
+	}
+
+	addr, port, err := parseAddressPort(target)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "failed to parse endpoint %q: %v", target, err)
+		return
+	}
+
+	members := setMembers(h.Controller.Lookup(addr, port), set.State().ID)
+
+	switch len(members) {
+	case 0:
+		writeAPIError(w, http.StatusNotFound, "endpoint %s is not a member of set %s", target, id)
+	case 1:
+		writeAPIResponse(w, http.StatusOK, newAPIEndpoint(members[0]))
+	default:
+		writeAPIError(w, http.StatusConflict, "address %s matches %d members of set %s; specify the port", target, len(members), id)
+	}
+}
+
+// findAPISet returns the dispatcher set with the given ID, writing an error response if there is none.
+func (h *Handler) findAPISet(w http.ResponseWriter, id string) (sets.DispatcherSet, bool) {
+	setID, err := strconv.Atoi(id)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid set ID %q", id)
+		return nil, false
+	}
+
+	for _, set := range h.Controller.Sets() {
+		if set.State().ID == setID {
+			return set, true
+		}
+	}
+
+	writeAPIError(w, http.StatusNotFound, "set %d does not exist", setID)
+
+	return nil, false
+}
+
+func writeAPIResponse(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	json.NewEncoder(w).Encode(body) // nolint: errcheck
+}
+
+func writeAPIError(w http.ResponseWriter, code int, format string, args ...interface{}) {
+	writeAPIResponse(w, code, &apiError{
+		Error: apiErrorDetail{
+			Code:    code,
+			Message: fmt.Sprintf(format, args...),
+		},
+	})
+}
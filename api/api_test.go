@@ -0,0 +1,171 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CyCoreSystems/dispatchers/v2"
+	"github.com/CyCoreSystems/dispatchers/v2/sets"
+)
+
+// exportFunc is a dispatchers.Exporter which exports with a function.
+type exportFunc func([]*sets.State) error
+
+func (f exportFunc) Export(states []*sets.State) error {
+	return f(states)
+}
+
+func newTestHandler(t *testing.T) *Handler {
+	t.Helper()
+
+	c := &dispatchers.Controller{
+		Exporter: exportFunc(func([]*sets.State) error {
+			return errors.New("disk full")
+		}),
+	}
+
+	c.AddSet(sets.NewStaticSet(1, []*sets.Endpoint{
+		{Address: "10.0.0.1", Port: 5060},
+		{Address: "10.0.0.1", Port: 5080},
+		{Address: "10.0.0.2", Port: 5060, NotReady: true},
+	}))
+	c.AddSet(sets.NewStaticSet(2, []*sets.Endpoint{{Address: "10.0.1.1", Port: 5060}}))
+
+	c.Export() // nolint: errcheck
+
+	return &Handler{Controller: c}
+}
+
+// serveAPI serves a request, checking that the response is JSON with the given status, and decodes its body into out, if it is given.
+func serveAPI(t *testing.T, h *Handler, method, path string, status int, out interface{}) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+
+	if w.Code != status {
+		t.Errorf("%s %s: expected status %d, got %d: %s", method, path, status, w.Code, w.Body)
+		return
+	}
+
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("%s %s: expected JSON, got %q", method, path, ct)
+	}
+
+	if out == nil {
+		return
+	}
+
+	if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+		t.Errorf("%s %s: failed to decode body %q: %v", method, path, w.Body, err)
+	}
+}
+
+func TestAPISets(t *testing.T) {
+	h := newTestHandler(t)
+
+	var list []*apiSet
+	serveAPI(t, h, "GET", "/api/v1/sets", http.StatusOK, &list)
+
+	if len(list) != 2 || list[0].ID != 1 || list[1].ID != 2 {
+		t.Fatalf("expected sets 1 and 2, got %+v", list)
+	}
+
+	if !list[0].Synced || len(list[0].Endpoints) != 3 {
+		t.Errorf("expected set 1 to be synced with 3 members, got %+v", list[0])
+	}
+
+	if ep := list[0].Endpoints[2]; ep.Address != "10.0.0.2" || ep.Ready {
+		t.Errorf("expected 10.0.0.2 to not be ready, got %+v", ep)
+	}
+
+	set := new(apiSet)
+	serveAPI(t, h, "GET", "/api/v1/sets/2", http.StatusOK, set)
+
+	if set.ID != 2 || len(set.Endpoints) != 1 || set.Endpoints[0].Address != "10.0.1.1" || !set.Endpoints[0].Ready {
+		t.Errorf("expected set 2 with its ready member, got %+v", set)
+	}
+}
+
+func TestAPIEndpoint(t *testing.T) {
+	h := newTestHandler(t)
+
+	ep := new(apiEndpoint)
+	serveAPI(t, h, "GET", "/api/v1/sets/1/endpoints/10.0.0.2", http.StatusOK, ep)
+
+	if ep.Address != "10.0.0.2" || ep.Port != 5060 {
+		t.Errorf("expected 10.0.0.2:5060, got %+v", ep)
+	}
+
+	ep = new(apiEndpoint)
+	serveAPI(t, h, "GET", "/api/v1/sets/1/endpoints/10.0.0.1:5080", http.StatusOK, ep)
+
+	if ep.Address != "10.0.0.1" || ep.Port != 5080 {
+		t.Errorf("expected 10.0.0.1:5080, got %+v", ep)
+	}
+
+	ep = new(apiEndpoint)
+	serveAPI(t, h, "GET", "/api/v1/sets/1/endpoints/::ffff:10.0.0.2", http.StatusOK, ep)
+
+	if ep.Address != "10.0.0.2" {
+		t.Errorf("expected the IPv4-mapped address to match 10.0.0.2, got %+v", ep)
+	}
+}
+
+func TestAPIStatus(t *testing.T) {
+	h := newTestHandler(t)
+
+	status := new(apiStatus)
+	serveAPI(t, h, "GET", "/api/v1/status", http.StatusOK, status)
+
+	if !status.Synced || status.Ready || status.Reason == "" {
+		t.Errorf("expected synced sets which are not ready, got %+v", status)
+	}
+
+	if status.Revision != h.Controller.Revision() {
+		t.Errorf("expected revision %d, got %d", h.Controller.Revision(), status.Revision)
+	}
+
+	if status.Export == nil || status.Export.Succeeded || status.Export.Time == nil || status.Export.Error != "disk full" {
+		t.Errorf("expected the failed export to be reported, got %+v", status.Export)
+	}
+
+	if status.Notify == nil || status.Notify.Succeeded || status.Notify.Time != nil {
+		t.Errorf("expected no notification to be reported, got %+v", status.Notify)
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	h := newTestHandler(t)
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{"GET", "/api/v1/sets/9", http.StatusNotFound},
+		{"GET", "/api/v1/sets/x", http.StatusBadRequest},
+		{"GET", "/api/v1/sets/1/endpoints/10.0.0.9", http.StatusNotFound},
+		{"GET", "/api/v1/sets/9/endpoints/10.0.0.1", http.StatusNotFound},
+		{"GET", "/api/v1/sets/1/endpoints/10.0.0.1", http.StatusConflict},
+		{"GET", "/api/v1/sets/1/endpoints/10.0.0.1:sip", http.StatusBadRequest},
+		{"GET", "/api/v1/sets/1/members", http.StatusNotFound},
+		{"GET", "/api/v1/nothing", http.StatusNotFound},
+		{"GET", "/api/v1/watch", http.StatusNotFound},
+		{"PUT", "/api/v1/sets", http.StatusMethodNotAllowed},
+		{"PATCH", "/api/v1/sets/1", http.StatusMethodNotAllowed},
+		{"POST", "/api/v1/export", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		body := new(apiError)
+		serveAPI(t, h, tt.method, tt.path, tt.status, body)
+
+		if body.Error.Code != tt.status || body.Error.Message == "" {
+			t.Errorf("%s %s: expected an error body with code %d and a message, got %+v", tt.method, tt.path, tt.status, body.Error)
+		}
+	}
+}
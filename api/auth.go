@@ -0,0 +1,318 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	authv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// apiScope is the level of access granted to a client of the web API.
+type apiScope int
+
+const (
+	// scopeNone grants no access.
+	scopeNone apiScope = iota
+
+	// scopeRead grants access to inspect the dispatcher sets and the status of the Controller.
+	scopeRead
+
+	// scopeAdmin grants access to modify the dispatcher sets, in addition to scopeRead.
+	scopeAdmin
+)
+
+// tokenReviewCacheTTL is the amount of time for which the result of a Kubernetes TokenReview is cached.
+var tokenReviewCacheTTL = time.Minute
+
+// tokenReviewTimeout is the maximum amount of time to wait for a Kubernetes TokenReview.
+var tokenReviewTimeout = 10 * time.Second
+
+// tokenReviewFailureRate and tokenReviewFailureBurst limit the rate of failed Kubernetes TokenReviews, so that clients presenting new invalid tokens cannot flood the API server with reviews.
+// Once the limit is reached, tokens which are not cached are refused without review until failures are permitted again.
+var (
+	tokenReviewFailureRate  = rate.Every(time.Second)
+	tokenReviewFailureBurst = 10
+)
+
+// errTooManyReviews is returned when a bearer token is not reviewed because too many reviews have failed recently.
+var errTooManyReviews = errors.New("too many failed token reviews; try again later")
+
+// apiIdentity describes an authenticated client of the web API.
+type apiIdentity struct {
+	Name   string
+	Groups []string
+}
+
+// Auth authenticates the clients of the web API and determines whether they may read or also administer it.
+//
+// Clients may authenticate with a bearer token, which is either one of the static tokens or, if a TokenReviewer is set, a Kubernetes ServiceAccount (or other) token, or with a TLS client certificate verified by the server.
+// Authenticated identities may administer the API if their name (the username of a token or the common name of a certificate) or one of their groups (the groups of a token or the organizations of a certificate) is one of the Admins, and may only read it otherwise.
+// Anonymous clients may read the API only if no means of authentication besides the AdminToken is configured.
+type Auth struct {
+	// ReadToken is an optional static bearer token which grants read access.
+	ReadToken string
+
+	// AdminToken is an optional static bearer token which grants administrative access.
+	AdminToken string
+
+	// TokenReviewer is an optional Kubernetes client with which bearer tokens are verified through the TokenReview API.
+	TokenReviewer kubernetes.Interface
+
+	// Audiences are the audiences for which bearer tokens verified by the TokenReviewer must have been issued, such as "dispatchers" for a projected ServiceAccount token with that audience.
+	// If empty, the audiences of the Kubernetes API server are used, so that any token of the API server is accepted and clients disclose tokens which are also valid for the API server.
+	Audiences []string
+
+	// Admins are the names and groups of the authenticated identities which are granted administrative access.
+	Admins []string
+
+	// ClientCertificates indicates that verified TLS client certificates are accepted.  The server must be configured to verify them, such as by NewTLSConfig.
+	ClientCertificates bool
+
+	reviews map[[sha256.Size]byte]*tokenReview
+
+	// failures limits the rate of failed reviews.
+	failures *rate.Limiter
+
+	mu sync.Mutex
+}
+
+type tokenReview struct {
+	identity *apiIdentity
+	expires  time.Time
+}
+
+// requiresRead indicates that clients must authenticate for scopeRead.
+// Anonymous clients are granted scopeRead only if no means of authentication besides the AdminToken is configured, as was the case before authentication was supported.
+func (a *Auth) requiresRead() bool {
+	return a != nil && (a.ReadToken != "" || a.TokenReviewer != nil || a.ClientCertificates)
+}
+
+// adminEnabled indicates that any client could be granted scopeAdmin.
+func (a *Auth) adminEnabled() bool {
+	return a != nil && (a.AdminToken != "" || len(a.Admins) > 0)
+}
+
+// authenticate determines the scope of a request.
+// An error is returned if the request carries credentials which are not valid.
+func (a *Auth) authenticate(r *http.Request) (apiScope, error) {
+	if a == nil {
+		return scopeRead, nil
+	}
+
+	if header := r.Header.Get("Authorization"); header != "" {
+		if !strings.HasPrefix(header, "Bearer ") {
+			return scopeNone, fmt.Errorf("unsupported authorization scheme")
+		}
+
+		return a.authenticateToken(r.Context(), strings.TrimPrefix(header, "Bearer "))
+	}
+
+	if a.ClientCertificates && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		subject := r.TLS.VerifiedChains[0][0].Subject
+
+		return a.scopeOf(&apiIdentity{
+			Name:   subject.CommonName,
+			Groups: subject.Organization,
+		}), nil
+	}
+
+	if a.requiresRead() {
+		return scopeNone, nil
+	}
+
+	return scopeRead, nil
+}
+
+func (a *Auth) authenticateToken(ctx context.Context, token string) (apiScope, error) {
+	if token == "" {
+		return scopeNone, fmt.Errorf("empty bearer token")
+	}
+
+	if a.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.AdminToken)) == 1 {
+		return scopeAdmin, nil
+	}
+
+	if a.ReadToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.ReadToken)) == 1 {
+		return scopeRead, nil
+	}
+
+	if a.TokenReviewer == nil {
+		return scopeNone, fmt.Errorf("invalid bearer token")
+	}
+
+	identity, err := a.review(ctx, token)
+	if err != nil {
+		return scopeNone, err
+	}
+
+	return a.scopeOf(identity), nil
+}
+
+// review verifies a bearer token through the Kubernetes TokenReview API, caching the result.
+// Tokens which are not cached are only reviewed while failed reviews are within their rate limit.
+func (a *Auth) review(ctx context.Context, token string) (*apiIdentity, error) {
+	key := sha256.Sum256([]byte(token))
+	now := time.Now()
+
+	a.mu.Lock()
+	if cached, ok := a.reviews[key]; ok && now.Before(cached.expires) {
+		a.mu.Unlock()
+
+		if cached.identity == nil {
+			return nil, fmt.Errorf("invalid bearer token")
+		}
+
+		return cached.identity, nil
+	}
+
+	if a.failures == nil {
+		a.failures = rate.NewLimiter(tokenReviewFailureRate, tokenReviewFailureBurst)
+	}
+
+	// The limit is only checked here; only reviews which fail count against it.
+	check := a.failures.ReserveN(now, 1)
+	limited := !check.OK() || check.DelayFrom(now) > 0
+	check.CancelAt(now)
+	a.mu.Unlock()
+
+	if limited {
+		return nil, errTooManyReviews
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, tokenReviewTimeout)
+	defer cancel()
+
+	result, err := a.TokenReviewer.AuthenticationV1().TokenReviews().Create(ctx, &authv1.TokenReview{
+		Spec: authv1.TokenReviewSpec{
+			Token:     token,
+			Audiences: a.Audiences,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		a.failures.Allow()
+
+		// Failures of the review itself are not cached.
+		return nil, fmt.Errorf("failed to review bearer token: %w", err)
+	}
+
+	var identity *apiIdentity
+
+	if result.Status.Authenticated && a.hasAudience(result.Status.Audiences) {
+		identity = &apiIdentity{
+			Name:   result.Status.User.Username,
+			Groups: result.Status.User.Groups,
+		}
+	}
+
+	a.mu.Lock()
+	if a.reviews == nil {
+		a.reviews = make(map[[sha256.Size]byte]*tokenReview)
+	}
+
+	for k, cached := range a.reviews {
+		if !now.Before(cached.expires) {
+			delete(a.reviews, k)
+		}
+	}
+
+	a.reviews[key] = &tokenReview{
+		identity: identity,
+		expires:  now.Add(tokenReviewCacheTTL),
+	}
+	a.mu.Unlock()
+
+	if identity == nil {
+		a.failures.Allow()
+
+		return nil, fmt.Errorf("invalid bearer token")
+	}
+
+	return identity, nil
+}
+
+// hasAudience indicates whether the audiences of a reviewed token include one of the Audiences, if any are required.
+func (a *Auth) hasAudience(audiences []string) bool {
+	if len(a.Audiences) == 0 {
+		return true
+	}
+
+	for _, required := range a.Audiences {
+		for _, audience := range audiences {
+			if audience == required {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (a *Auth) scopeOf(identity *apiIdentity) apiScope {
+	for _, admin := range a.Admins {
+		if identity.Name == admin {
+			return scopeAdmin
+		}
+
+		for _, group := range identity.Groups {
+			if group == admin {
+				return scopeAdmin
+			}
+		}
+	}
+
+	return scopeRead
+}
+
+// authorize checks that a request is granted the given scope, writing an error response if it is not.
+func (h *Handler) authorize(w http.ResponseWriter, r *http.Request, required apiScope) bool {
+	if required == scopeAdmin && !h.Auth.adminEnabled() {
+		writeAPIError(w, http.StatusForbidden, "administration is disabled")
+		return false
+	}
+
+	scope, err := h.Auth.authenticate(r)
+	if errors.Is(err, errTooManyReviews) {
+		w.Header().Set("Retry-After", "1")
+		writeAPIError(w, http.StatusTooManyRequests, "%s", err.Error())
+
+		return false
+	}
+
+	if err != nil || scope == scopeNone {
+		message := "authentication is required"
+		if err != nil {
+			message = err.Error()
+		}
+
+		w.Header().Set("WWW-Authenticate", `Bearer realm="dispatchers"`)
+		writeAPIError(w, http.StatusUnauthorized, "%s", message)
+
+		return false
+	}
+
+	if scope < required {
+		writeAPIError(w, http.StatusForbidden, "administrative access is required")
+		return false
+	}
+
+	return true
+}
+
+// requireRead wraps a handler so that it is only served to clients granted scopeRead.
+func (h *Handler) requireRead(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.authorize(w, r, scopeRead) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
@@ -0,0 +1,450 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+	authv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// tokenReviewer returns a fake Kubernetes client whose TokenReviews authenticate the given tokens, for the given audiences, and counts the reviews.
+func tokenReviewer(t *testing.T, users map[string]authv1.UserInfo, audiences []string) (*fake.Clientset, *int32) {
+	t.Helper()
+
+	var reviews int32
+
+	kc := fake.NewSimpleClientset()
+	kc.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		atomic.AddInt32(&reviews, 1)
+
+		review := action.(k8stesting.CreateAction).GetObject().(*authv1.TokenReview).DeepCopy()
+
+		user, ok := users[review.Spec.Token]
+		if !ok {
+			return true, review, nil
+		}
+
+		// As the API server, the token must be valid for one of the requested audiences, which are returned.
+		if len(review.Spec.Audiences) > 0 {
+			var matched []string
+
+			for _, requested := range review.Spec.Audiences {
+				for _, audience := range audiences {
+					if requested == audience {
+						matched = append(matched, audience)
+					}
+				}
+			}
+
+			if len(matched) == 0 {
+				review.Status.Error = "token audiences are invalid"
+				return true, review, nil
+			}
+
+			review.Status.Audiences = matched
+		}
+
+		review.Status.Authenticated = true
+		review.Status.User = user
+
+		return true, review, nil
+	})
+
+	return kc, &reviews
+}
+
+func bearerRequest(token string) *http.Request {
+	r := httptest.NewRequest("GET", "/api/v1/sets", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	return r
+}
+
+func TestAuthScopes(t *testing.T) {
+	kc, reviews := tokenReviewer(t, map[string]authv1.UserInfo{
+		"operator-token": {Username: "system:serviceaccount:sip:sip-operator"},
+		"ops-token":      {Username: "system:serviceaccount:sip:other", Groups: []string{"ops"}},
+		"reader-token":   {Username: "system:serviceaccount:sip:reader"},
+	}, []string{"dispatchers"})
+
+	a := &Auth{
+		ReadToken:     "static-read",
+		AdminToken:    "static-admin",
+		TokenReviewer: kc,
+		Audiences:     []string{"dispatchers"},
+		Admins:        []string{"system:serviceaccount:sip:sip-operator", "ops"},
+	}
+
+	tests := []struct {
+		name  string
+		r     *http.Request
+		scope apiScope
+		err   bool
+	}{
+		{"anonymous", httptest.NewRequest("GET", "/api/v1/sets", nil), scopeNone, false},
+		{"static read", bearerRequest("static-read"), scopeRead, false},
+		{"static admin", bearerRequest("static-admin"), scopeAdmin, false},
+		{"admin by name", bearerRequest("operator-token"), scopeAdmin, false},
+		{"admin by group", bearerRequest("ops-token"), scopeAdmin, false},
+		{"reader", bearerRequest("reader-token"), scopeRead, false},
+		{"invalid", bearerRequest("invalid-token"), scopeNone, true},
+		{"empty", bearerRequest(""), scopeNone, true},
+	}
+
+	for _, tt := range tests {
+		scope, err := a.authenticate(tt.r)
+		if scope != tt.scope || (err != nil) != tt.err {
+			t.Errorf("%s: expected scope %d (error %v), got %d (%v)", tt.name, tt.scope, tt.err, scope, err)
+		}
+	}
+
+	before := atomic.LoadInt32(reviews)
+
+	// Results are cached, whether successful or not.
+	for _, token := range []string{"operator-token", "invalid-token"} {
+		a.authenticate(bearerRequest(token)) // nolint: errcheck
+	}
+
+	if n := atomic.LoadInt32(reviews); n != before {
+		t.Errorf("expected cached reviews to not be repeated, got %d more reviews", n-before)
+	}
+}
+
+func TestAuthAudiences(t *testing.T) {
+	// The token was issued for the API server only.
+	kc, _ := tokenReviewer(t, map[string]authv1.UserInfo{
+		"apiserver-token": {Username: "system:serviceaccount:sip:sip-operator"},
+	}, []string{"https://kubernetes.default.svc"})
+
+	a := &Auth{
+		TokenReviewer: kc,
+		Audiences:     []string{"dispatchers"},
+	}
+
+	if scope, err := a.authenticate(bearerRequest("apiserver-token")); err == nil || scope != scopeNone {
+		t.Errorf("expected a token of another audience to be refused, got scope %d", scope)
+	}
+
+	var requested []string
+
+	kc.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		requested = action.(k8stesting.CreateAction).GetObject().(*authv1.TokenReview).Spec.Audiences
+		return false, nil, nil
+	})
+
+	// Without Audiences, the audiences of the API server are used.
+	a = &Auth{TokenReviewer: kc}
+
+	if scope, err := a.authenticate(bearerRequest("apiserver-token")); err != nil || scope != scopeRead {
+		t.Errorf("expected a token of the API server to be accepted without Audiences, got scope %d (%v)", scope, err)
+	}
+
+	if len(requested) != 0 {
+		t.Errorf("expected no audiences to be requested, got %v", requested)
+	}
+}
+
+func TestAuthReviewFailureLimit(t *testing.T) {
+	defer func(r rate.Limit, b int) {
+		tokenReviewFailureRate, tokenReviewFailureBurst = r, b
+	}(tokenReviewFailureRate, tokenReviewFailureBurst)
+
+	tokenReviewFailureRate = rate.Every(time.Hour)
+	tokenReviewFailureBurst = 2
+
+	kc, reviews := tokenReviewer(t, map[string]authv1.UserInfo{
+		"reader-token": {Username: "reader"},
+		"other-token":  {Username: "other"},
+	}, nil)
+
+	a := &Auth{TokenReviewer: kc}
+
+	// Successful reviews do not count against the limit.
+	for _, token := range []string{"reader-token", "other-token", "random-1", "random-2"} {
+		if _, err := a.authenticate(bearerRequest(token)); err == errTooManyReviews {
+			t.Fatalf("review of %s was refused", token)
+		}
+	}
+
+	if n := atomic.LoadInt32(reviews); n != 4 {
+		t.Fatalf("expected 4 reviews, got %d", n)
+	}
+
+	if _, err := a.authenticate(bearerRequest("random-3")); err != errTooManyReviews {
+		t.Fatalf("expected the review to be refused once failures exceed the limit, got %v", err)
+	}
+
+	if n := atomic.LoadInt32(reviews); n != 4 {
+		t.Errorf("expected the refused token to not be reviewed, got %d reviews", n)
+	}
+
+	// Cached results are still served.
+	if scope, err := a.authenticate(bearerRequest("reader-token")); err != nil || scope != scopeRead {
+		t.Errorf("expected the cached token to be accepted, got scope %d (%v)", scope, err)
+	}
+
+	h := &Handler{Auth: a}
+
+	w := httptest.NewRecorder()
+	if h.authorize(w, bearerRequest("random-4"), scopeRead) {
+		t.Fatal("expected the request to be refused")
+	}
+
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("expected 429 with Retry-After, got %d", w.Code)
+	}
+}
+
+// testPKI is a certificate authority with which test certificates are issued.
+type testPKI struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+
+	serial int64
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+
+	p := new(testPKI)
+	p.cert, p.key = p.issue(t, pkix.Name{CommonName: "test CA"}, true, nil, nil)
+
+	return p
+}
+
+// issue issues a certificate, signed by the authority unless it is nil.
+func (p *testPKI) issue(t *testing.T, subject pkix.Name, isCA bool, usage []x509.ExtKeyUsage, dns []string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	p.serial++
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(p.serial),
+		Subject:               subject,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           usage,
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		DNSNames:              dns,
+	}
+
+	parent, signer := tmpl, key
+	if p.cert != nil {
+		parent, signer = p.cert, p.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+
+	return cert, key
+}
+
+func certPEM(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
+
+func keyPEM(t *testing.T, key *ecdsa.PrivateKey) []byte {
+	t.Helper()
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to encode key: %v", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func writeFile(t *testing.T, name string, data []byte, modTime time.Time) {
+	t.Helper()
+
+	if err := ioutil.WriteFile(name, data, 0600); err != nil {
+		t.Fatalf("failed to write %s: %v", name, err)
+	}
+
+	if err := os.Chtimes(name, modTime, modTime); err != nil {
+		t.Fatalf("failed to set modification time of %s: %v", name, err)
+	}
+}
+
+func TestAuthClientCertificates(t *testing.T) {
+	pki := newTestPKI(t)
+	dir := t.TempDir()
+
+	serverCert, serverKey := pki.issue(t, pkix.Name{CommonName: "dispatchers"}, false, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, []string{"dispatchers.test"})
+
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	caFile := filepath.Join(dir, "ca.crt")
+
+	now := time.Now()
+	writeFile(t, certFile, certPEM(serverCert), now)
+	writeFile(t, keyFile, keyPEM(t, serverKey), now)
+	writeFile(t, caFile, certPEM(pki.cert), now)
+
+	cfg, err := NewTLSConfig(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatalf("failed to create TLS configuration: %v", err)
+	}
+
+	a := &Auth{
+		ClientCertificates: true,
+		Admins:             []string{"sip-operator", "ops"},
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope, err := a.authenticate(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		fmt.Fprint(w, scope)
+	}))
+	srv.TLS = cfg
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(pki.cert)
+
+	scopeOf := func(subject *pkix.Name) string {
+		t.Helper()
+
+		clientCfg := &tls.Config{
+			RootCAs:    roots,
+			ServerName: "dispatchers.test",
+		}
+
+		if subject != nil {
+			cert, key := pki.issue(t, *subject, false, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, nil)
+
+			clientCfg.Certificates = []tls.Certificate{{
+				Certificate: [][]byte{cert.Raw},
+				PrivateKey:  key,
+			}}
+		}
+
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientCfg}}
+		defer client.CloseIdleConnections()
+
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		defer resp.Body.Close()
+
+		body, _ := ioutil.ReadAll(resp.Body) // nolint: errcheck
+
+		return string(body)
+	}
+
+	tests := []struct {
+		name    string
+		subject *pkix.Name
+		scope   apiScope
+	}{
+		{"no certificate", nil, scopeNone},
+		{"admin by common name", &pkix.Name{CommonName: "sip-operator"}, scopeAdmin},
+		{"admin by organization", &pkix.Name{CommonName: "someone", Organization: []string{"ops"}}, scopeAdmin},
+		{"reader", &pkix.Name{CommonName: "someone", Organization: []string{"dev"}}, scopeRead},
+	}
+
+	for _, tt := range tests {
+		if got := scopeOf(tt.subject); got != fmt.Sprint(tt.scope) {
+			t.Errorf("%s: expected scope %d, got %q", tt.name, tt.scope, got)
+		}
+	}
+}
+
+func TestTLSConfigReload(t *testing.T) {
+	pki := newTestPKI(t)
+	dir := t.TempDir()
+
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+
+	first, firstKey := pki.issue(t, pkix.Name{CommonName: "first"}, false, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, nil)
+
+	modTime := time.Now().Add(-time.Minute)
+	writeFile(t, certFile, certPEM(first), modTime)
+	writeFile(t, keyFile, keyPEM(t, firstKey), modTime)
+
+	cfg, err := NewTLSConfig(certFile, keyFile, "")
+	if err != nil {
+		t.Fatalf("failed to create TLS configuration: %v", err)
+	}
+
+	serving := func() string {
+		t.Helper()
+
+		cert, err := cfg.GetCertificate(nil)
+		if err != nil {
+			t.Fatalf("failed to get certificate: %v", err)
+		}
+
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatalf("failed to parse certificate: %v", err)
+		}
+
+		return leaf.Subject.CommonName
+	}
+
+	if name := serving(); name != "first" {
+		t.Fatalf("expected the first certificate, got %q", name)
+	}
+
+	// A rotated certificate is used once both of its files have been replaced.
+	second, secondKey := pki.issue(t, pkix.Name{CommonName: "second"}, false, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, nil)
+
+	modTime = modTime.Add(time.Second)
+	writeFile(t, certFile, certPEM(second), modTime)
+	writeFile(t, keyFile, keyPEM(t, secondKey), modTime)
+
+	if name := serving(); name != "second" {
+		t.Fatalf("expected the rotated certificate, got %q", name)
+	}
+
+	// A certificate which does not match its key is not loaded, and the previous one continues to be served.
+	third, _ := pki.issue(t, pkix.Name{CommonName: "third"}, false, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, nil)
+
+	modTime = modTime.Add(time.Second)
+	writeFile(t, certFile, certPEM(third), modTime)
+
+	if name := serving(); name != "second" {
+		t.Errorf("expected the previous certificate to be kept, got %q", name)
+	}
+}
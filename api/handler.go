@@ -0,0 +1,286 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/CyCoreSystems/dispatchers/v2"
+	"github.com/CyCoreSystems/dispatchers/v2/sets"
+	"inet.af/netaddr"
+)
+
+// Handler serves the web API of a dispatchers.Controller:  version 1 of the REST API under /api/v1/, the legacy /check/, /dispatcher/, /dispatchers/, and /lookup/ routes, health checks at /healthz and /readyz, and, optionally, Prometheus metrics at /metrics.
+// It may be mounted in any server;  use http.StripPrefix to serve it under a prefix.
+// The Handler must not be modified once it has served a request.
+type Handler struct {
+	// Controller is the Controller whose dispatcher sets are served.
+	Controller *dispatchers.Controller
+
+	// Metrics is an optional handler for Prometheus metrics.
+	Metrics http.Handler
+
+	// Feed is an optional source of changes for the watch stream.  It must be an Observer of the Controller.
+	Feed *WatchFeed
+
+	// Auth is the optional authentication of clients.  If it is nil, all clients may read, and none may administer.
+	Auth *Auth
+
+	mux  *http.ServeMux
+	once sync.Once
+}
+
+// ServeHTTP implements http.Handler
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.once.Do(func() {
+		h.mux = http.NewServeMux()
+
+		h.mux.Handle("/check/", h.requireRead(http.HandlerFunc(h.handleIPCheckRequest)))
+		h.mux.Handle("/dispatcher/", h.requireRead(http.HandlerFunc(h.handleListSetRequest)))
+		h.mux.Handle("/dispatchers/", h.requireRead(http.HandlerFunc(h.handleListSetRequest)))
+		h.mux.Handle("/lookup/", h.requireRead(http.HandlerFunc(h.handleLookupRequest)))
+		h.mux.HandleFunc(apiPrefix, h.handleAPIRequest)
+		h.mux.HandleFunc("/healthz", h.handleHealthRequest)
+		h.mux.HandleFunc("/readyz", h.handleReadyRequest)
+
+		if h.Metrics != nil {
+			h.mux.Handle("/metrics", h.requireRead(h.Metrics))
+		}
+	})
+
+	h.mux.ServeHTTP(w, r)
+}
+
+// Report that the service is running.
+// URL:  /healthz
+func (h *Handler) handleHealthRequest(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "ok")
+}
+
+// Report whether the dispatcher sets have been synced, exported, and loaded by kamailio.
+// URL:  /readyz
+func (h *Handler) handleReadyRequest(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	if err := h.Controller.Ready(); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, "not ready:", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "ok")
+}
+
+// Check IP address for membership in a dispatcher set.
+// The address may be an IP address, an IP address and port (such as 10.0.0.1:5060 or [fd00::1]:5060), a hostname with an optional port, which matches members of static sets case-insensitively, or a CIDR prefix, in which case any member within the prefix matches.
+// URL:  /check/<setID>/<ip>
+//
+// Find the dispatcher sets of which an IP address is a member.
+// The IDs of the sets are returned as a JSON array.
+// URL:  /check/<ip>
+func (h *Handler) handleIPCheckRequest(w http.ResponseWriter, r *http.Request) {
+	pieces := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/check/"), "/", 2)
+
+	if len(pieces) == 1 {
+		h.handleIPSetsRequest(w, pieces[0])
+		return
+	}
+
+	setID, err := strconv.Atoi(pieces[0])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var members []*sets.Endpoint
+
+	if strings.Contains(pieces[1], "/") {
+		prefix, err := netaddr.ParseIPPrefix(pieces[1])
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		members = setMembers(h.Controller.LookupPrefix(prefix), setID)
+	} else {
+		addr, port, err := parseMemberAddress(pieces[1])
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		members = setMembers(h.Controller.Lookup(addr, port), setID)
+	}
+
+	if len(members) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) handleIPSetsRequest(w http.ResponseWriter, target string) {
+	addr, port, err := parseMemberAddress(target)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	ids := []int{}
+	for _, state := range h.Controller.Lookup(addr, port) {
+		ids = append(ids, state.ID)
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+
+	if len(ids) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+	}
+
+	json.NewEncoder(w).Encode(ids) // nolint: errcheck
+}
+
+// Find the dispatcher sets and members which match an IP address and optional port.
+// By default, the matching sets are returned as JSON, each with only its matching members.
+// If the format=text query parameter is given, or plain text is preferred by the Accept header, the first line of the response is a comma-separated list of the IDs of the matching sets, suitable for kamailio's http_client module, and it is followed by one line for each matching member of the form "<setID> <ip>:<port> [key=value;...]".
+// URL:  /lookup/<ip>[:port]
+func (h *Handler) handleLookupRequest(w http.ResponseWriter, r *http.Request) {
+	addr, port, err := parseAddressPort(strings.TrimPrefix(r.URL.Path, "/lookup/"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := sets.ParseIP(addr); !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	matches := h.Controller.Lookup(addr, port)
+
+	if r.URL.Query().Get("format") == "text" || strings.HasPrefix(r.Header.Get("Accept"), "text/plain") {
+		writeLookupText(w, matches)
+		return
+	}
+
+	if matches == nil {
+		matches = []*sets.State{}
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+
+	if len(matches) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+	}
+
+	json.NewEncoder(w).Encode(matches) // nolint: errcheck
+}
+
+func writeLookupText(w http.ResponseWriter, matches []*sets.State) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	if len(matches) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+	}
+
+	ids := make([]string, 0, len(matches))
+	for _, state := range matches {
+		ids = append(ids, strconv.Itoa(state.ID))
+	}
+
+	fmt.Fprintln(w, strings.Join(ids, ","))
+
+	for _, state := range matches {
+		for _, ep := range state.Endpoints {
+			if attrs := sets.FormatAttributes(ep.Attributes); attrs != "" {
+				fmt.Fprintf(w, "%d %s %s\n", state.ID, ep, attrs)
+			} else {
+				fmt.Fprintf(w, "%d %s\n", state.ID, ep)
+			}
+		}
+	}
+}
+
+// setMembers returns the matching members of the given set from the results of a Controller lookup.
+func setMembers(matches []*sets.State, setID int) []*sets.Endpoint {
+	for _, state := range matches {
+		if state.ID == setID {
+			return state.Endpoints
+		}
+	}
+
+	return nil
+}
+
+// parseAddressPort parses an address of the form ip, ip:port, or [ip]:port.
+// The port is 0 if it is not specified.
+func parseAddressPort(s string) (addr string, port uint32, err error) {
+	if _, ok := sets.ParseIP(s); ok {
+		return s, 0, nil
+	}
+
+	host, portString, err := net.SplitHostPort(s)
+	if err != nil {
+		return "", 0, err
+	}
+
+	p, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return host, uint32(p), nil
+}
+
+// parseMemberAddress parses an address as parseAddressPort does, but also accepts a hostname without a port, such as that of a member of a static set.
+func parseMemberAddress(s string) (addr string, port uint32, err error) {
+	addr, port, err = parseAddressPort(s)
+	if err != nil && s != "" && !strings.Contains(s, ":") {
+		return s, 0, nil
+	}
+
+	return addr, port, err
+}
+
+// Return a given dispatcher set
+// URL:  /dispatcher/<setID>
+// URL:  /dispatchers/<setID>
+func (h *Handler) handleListSetRequest(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/dispatchers/")
+	path = strings.TrimPrefix(path, "/dispatcher/")
+
+	pieces := strings.Split(path, "/")
+	if len(pieces) != 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	setID, err := strconv.Atoi(pieces[0])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	for _, set := range h.Controller.CurrentState() {
+		if set.ID != setID {
+			continue
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+
+		if err = json.NewEncoder(w).Encode(set); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNotFound)
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CyCoreSystems/dispatchers/v2"
+	"github.com/CyCoreSystems/dispatchers/v2/sets"
+)
+
+func TestHandleIPCheckRequest(t *testing.T) {
+	c := new(dispatchers.Controller)
+	c.AddSet(sets.NewStaticSet(1, []*sets.Endpoint{{Address: "10.0.0.1", Port: 5060}, {Address: "fd00::1", Port: 5060}}))
+	c.AddSet(sets.NewStaticSet(2, []*sets.Endpoint{{Address: "Trunk.Example.com", Port: 5061}}))
+
+	h := &Handler{Controller: c}
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/check/1/10.0.0.1", http.StatusOK},
+		{"/check/1/::ffff:10.0.0.1", http.StatusOK},
+		{"/check/1/10.0.0.1:5060", http.StatusOK},
+		{"/check/1/10.0.0.1:5080", http.StatusNotFound},
+		{"/check/1/[fd00::1]:5060", http.StatusOK},
+		{"/check/1/10.0.0.0/24", http.StatusOK},
+		{"/check/1/10.0.0.2", http.StatusNotFound},
+		{"/check/2/10.0.0.1", http.StatusNotFound},
+		{"/check/2/trunk.example.com", http.StatusOK},
+		{"/check/2/TRUNK.example.com:5061", http.StatusOK},
+		{"/check/2/trunk.example.com:5060", http.StatusNotFound},
+		{"/check/1/trunk.example.com", http.StatusNotFound},
+		{"/check/2/trunk.example.com:sip", http.StatusBadRequest},
+		{"/check/x/10.0.0.1", http.StatusBadRequest},
+		{"/check/10.0.0.1", http.StatusOK},
+		{"/check/trunk.example.com", http.StatusOK},
+		{"/check/10.0.0.2", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+
+		if w.Code != tt.status {
+			t.Errorf("GET %s: expected status %d, got %d", tt.path, tt.status, w.Code)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// DefaultShutdownTimeout is the default maximum amount of time a Server waits for its requests to complete when it is shut down.
+const DefaultShutdownTimeout = 10 * time.Second
+
+// Server serves an http.Handler, such as a Handler, on its own address until its context is cancelled.
+type Server struct {
+	// Addr is the address on which to listen, such as ":8080".
+	Addr string
+
+	// Handler is the handler to serve.
+	Handler http.Handler
+
+	// TLSConfig is the optional TLS configuration, such as from NewTLSConfig.  If it is nil, plain HTTP is served.
+	TLSConfig *tls.Config
+
+	// ShutdownTimeout is the maximum amount of time to wait for requests to complete when the Server is shut down.  If it is zero, DefaultShutdownTimeout is used.
+	ShutdownTimeout time.Duration
+}
+
+// Run serves until the context is cancelled, at which point the Server is shut down gracefully:  it stops accepting connections and waits for its requests to complete.
+// Long-lived requests, such as watches, are cancelled.
+// It returns nil once it has been shut down, or an error if it fails to serve.
+func (s *Server) Run(ctx context.Context) error {
+	requestCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+
+	srv := &http.Server{
+		Addr:      s.Addr,
+		Handler:   s.Handler,
+		TLSConfig: s.TLSConfig,
+		BaseContext: func(net.Listener) context.Context {
+			return requestCtx
+		},
+	}
+
+	srv.RegisterOnShutdown(cancelRequests)
+
+	errs := make(chan error, 1)
+
+	go func() {
+		if s.TLSConfig != nil {
+			errs <- srv.ListenAndServeTLS("", "")
+			return
+		}
+
+		errs <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return fmt.Errorf("failed to serve on %s: %w", s.Addr, err)
+	case <-ctx.Done():
+	}
+
+	timeout := s.ShutdownTimeout
+	if timeout == 0 {
+		timeout = DefaultShutdownTimeout
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to shut down server on %s: %w", s.Addr, err)
+	}
+
+	return nil
+}
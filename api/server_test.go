@@ -0,0 +1,275 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// freeAddr returns a local address on which nothing is listening.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer l.Close()
+
+	return l.Addr().String()
+}
+
+// runServer runs a Server until the returned function is called, which waits for Run to return and returns its error.
+func runServer(t *testing.T, s *Server, client *http.Client, url string) func() error {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+
+	go func() {
+		errs <- s.Run(ctx)
+	}()
+
+	t.Cleanup(cancel)
+
+	// Wait for the Server to be listening.
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		resp, err := client.Get(url)
+		if err == nil {
+			resp.Body.Close()
+			break
+		}
+
+		select {
+		case err = <-errs:
+			t.Fatalf("server failed: %v", err)
+		default:
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the server: %v", err)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return func() error {
+		cancel()
+
+		select {
+		case err := <-errs:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the server to shut down")
+			return nil
+		}
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		fmt.Fprint(w, "done")
+	})
+	mux.HandleFunc("/watch", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		started <- struct{}{}
+		<-r.Context().Done()
+	})
+
+	addr := freeAddr(t)
+	url := "http://" + addr
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+	stop := runServer(t, &Server{Addr: addr, Handler: mux}, client, url+"/healthz")
+
+	slow := make(chan string, 1)
+
+	go func() {
+		resp, err := client.Get(url + "/slow")
+		if err != nil {
+			slow <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+
+		body, _ := ioutil.ReadAll(resp.Body) // nolint: errcheck
+		slow <- string(body)
+	}()
+
+	watch, err := client.Get(url + "/watch")
+	if err != nil {
+		t.Fatalf("watch failed: %v", err)
+	}
+	defer watch.Body.Close()
+
+	<-started
+	<-started
+
+	stopped := make(chan error, 1)
+
+	go func() {
+		stopped <- stop()
+	}()
+
+	// The watch is cancelled, so its stream ends.
+	if _, err = ioutil.ReadAll(watch.Body); err != nil {
+		t.Errorf("expected the watch to end, got %v", err)
+	}
+
+	// The Server waits for the in-flight request to complete.
+	select {
+	case err = <-stopped:
+		t.Fatalf("expected the shutdown to wait for the in-flight request, but it returned %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+
+	if err = <-stopped; err != nil {
+		t.Errorf("expected a graceful shutdown, got %v", err)
+	}
+
+	if body := <-slow; body != "done" {
+		t.Errorf("expected the in-flight request to complete, got %q", body)
+	}
+
+	// No new connections are accepted.
+	if _, err = client.Get(url + "/healthz"); err == nil {
+		t.Error("expected the stopped server to refuse requests")
+	}
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/stuck", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+
+	addr := freeAddr(t)
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+	stop := runServer(t, &Server{Addr: addr, Handler: mux, ShutdownTimeout: 50 * time.Millisecond}, client, "http://"+addr+"/healthz")
+
+	go client.Get("http://" + addr + "/stuck") // nolint: errcheck
+
+	<-started
+
+	if err := stop(); err == nil {
+		t.Error("expected the shutdown to fail when a request outlasts the timeout")
+	}
+}
+
+func TestServerListenError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer l.Close()
+
+	// The error is returned, rather than the process exiting, without waiting for the context.
+	errs := make(chan error, 1)
+
+	go func() {
+		errs <- (&Server{Addr: l.Addr().String(), Handler: http.NotFoundHandler()}).Run(context.Background())
+	}()
+
+	select {
+	case err = <-errs:
+		if err == nil {
+			t.Error("expected an error for an address which is in use")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the listen error")
+	}
+}
+
+func TestServerTLSReload(t *testing.T) {
+	pki := newTestPKI(t)
+	dir := t.TempDir()
+
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+
+	issue := func(name string, modTime time.Time) {
+		cert, key := pki.issue(t, pkix.Name{CommonName: name}, false, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, []string{"dispatchers.test"})
+
+		writeFile(t, certFile, certPEM(cert), modTime)
+		writeFile(t, keyFile, keyPEM(t, key), modTime)
+	}
+
+	modTime := time.Now().Add(-time.Minute)
+	issue("first", modTime)
+
+	cfg, err := NewTLSConfig(certFile, keyFile, "")
+	if err != nil {
+		t.Fatalf("failed to create TLS configuration: %v", err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(pki.cert)
+
+	// Each request is made on a new connection, so that it sees the current certificate.
+	client := &http.Client{Transport: &http.Transport{
+		DisableKeepAlives: true,
+		TLSClientConfig:   &tls.Config{RootCAs: roots, ServerName: "dispatchers.test"},
+	}}
+
+	addr := freeAddr(t)
+	url := "https://" + addr + "/healthz"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {})
+
+	stop := runServer(t, &Server{Addr: addr, Handler: mux, TLSConfig: cfg}, client, url)
+
+	serving := func() string {
+		t.Helper()
+
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+
+		return resp.TLS.PeerCertificates[0].Subject.CommonName
+	}
+
+	if name := serving(); name != "first" {
+		t.Errorf("expected the first certificate, got %q", name)
+	}
+
+	issue("second", modTime.Add(time.Second))
+
+	if name := serving(); name != "second" {
+		t.Errorf("expected the rotated certificate without a restart, got %q", name)
+	}
+
+	if err = stop(); err != nil {
+		t.Errorf("expected a graceful shutdown, got %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// tlsReloader serves a TLS certificate and, optionally, a client certificate authority, reloading them from their files whenever they change, so that rotated certificates (such as those of cert-manager) are used without a restart.
+type tlsReloader struct {
+	certFile string
+	keyFile  string
+
+	// clientCAFile is the optional file of PEM-encoded certificate authorities with which client certificates are verified.
+	clientCAFile string
+
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+
+	// modTimes are the modification times of the files when they were last loaded.
+	modTimes [3]time.Time
+
+	mu sync.Mutex
+}
+
+// NewTLSConfig creates a TLS configuration for the web API from the given certificate, key, and optional client certificate authority files.
+// If a client certificate authority is given, client certificates are verified against it, if they are presented.
+func NewTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("both a certificate and a key are required")
+	}
+
+	t := &tlsReloader{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+	}
+
+	if err := t.reload(); err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &t.config().Certificates[0], nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return t.config(), nil
+		},
+	}, nil
+}
+
+// config returns the TLS configuration for a new connection, with the current certificates.
+func (t *tlsReloader) config() *tls.Config {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.changed() {
+		if err := t.reload(); err != nil {
+			log.Println("failed to reload TLS certificates; continuing to use the previous ones:", err)
+		}
+	}
+
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{*t.cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+
+	if t.clientCAs != nil {
+		// Client certificates are optional, so that health checks and bearer token clients may connect without them.
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+		cfg.ClientCAs = t.clientCAs
+	}
+
+	return cfg
+}
+
+func (t *tlsReloader) files() []string {
+	return []string{t.certFile, t.keyFile, t.clientCAFile}
+}
+
+// changed indicates that any of the files have been modified since they were last loaded.  The lock must be held.
+func (t *tlsReloader) changed() bool {
+	for i, name := range t.files() {
+		if name == "" {
+			continue
+		}
+
+		info, err := os.Stat(name)
+		if err != nil {
+			continue
+		}
+
+		if !info.ModTime().Equal(t.modTimes[i]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reload loads the files.  The current certificates are kept unless all of the files are loaded successfully.  The lock must be held.
+func (t *tlsReloader) reload() error {
+	var modTimes [3]time.Time
+
+	for i, name := range t.files() {
+		if name == "" {
+			continue
+		}
+
+		info, err := os.Stat(name)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", name, err)
+		}
+
+		modTimes[i] = info.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(t.certFile, t.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate %s and key %s: %w", t.certFile, t.keyFile, err)
+	}
+
+	var clientCAs *x509.CertPool
+
+	if t.clientCAFile != "" {
+		data, err := ioutil.ReadFile(t.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client certificate authority %s: %w", t.clientCAFile, err)
+		}
+
+		clientCAs = x509.NewCertPool()
+
+		if !clientCAs.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates found in client certificate authority %s", t.clientCAFile)
+		}
+	}
+
+	t.cert = &cert
+	t.clientCAs = clientCAs
+	t.modTimes = modTimes
+
+	return nil
+}
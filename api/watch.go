@@ -0,0 +1,309 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CyCoreSystems/dispatchers/v2"
+	"github.com/CyCoreSystems/dispatchers/v2/sets"
+)
+
+// watchHistory is the number of changes retained for the resumption of watches.
+const watchHistory = 1024
+
+// watchBuffer is the number of changes which may be queued for a watcher before it is disconnected for being too slow.
+const watchBuffer = 64
+
+// watchKeepAlive is the interval at which a comment is sent to idle watchers, to keep their connections open.
+var watchKeepAlive = 30 * time.Second
+
+// watchSnapshot is the data of a "snapshot" event of the watch stream:  the complete state of the dispatcher sets.
+type watchSnapshot struct {
+	Revision uint64    `json:"revision"`
+	Sets     []*apiSet `json:"sets"`
+}
+
+// watchDiff is the data of a "diff" event of the watch stream:  the change of a single dispatcher set.
+type watchDiff struct {
+	Revision uint64 `json:"revision"`
+	Set      int    `json:"set"`
+	Version  uint64 `json:"version"`
+
+	// Added are the members which have joined the set.
+	Added []*apiEndpoint `json:"added"`
+
+	// Removed are the members which have left the set.
+	Removed []*apiEndpoint `json:"removed"`
+
+	// Updated are the members whose readiness, drain, or attributes have changed.
+	Updated []*apiEndpoint `json:"updated"`
+}
+
+// WatchFeed is a dispatchers.Observer which records the changes of the dispatcher sets as diffs and distributes them to watchers.
+type WatchFeed struct {
+	c *dispatchers.Controller
+
+	// epoch identifies this feed (and so this process) in the IDs of its events, since revisions restart at zero when the daemon restarts.
+	epoch string
+
+	// last is the most recent state of each set.
+	last map[int]*sets.State
+
+	// history is the most recent diffs, in order of revision.
+	history []*watchDiff
+
+	subscribers map[chan *watchDiff]struct{}
+
+	mu sync.Mutex
+}
+
+// NewWatchFeed creates a WatchFeed of the dispatcher sets of the given Controller, whose Observer it must be made.
+func NewWatchFeed(c *dispatchers.Controller) *WatchFeed {
+	f := &WatchFeed{
+		c:           c,
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		last:        make(map[int]*sets.State),
+		subscribers: make(map[chan *watchDiff]struct{}),
+	}
+
+	for _, state := range c.CurrentState() {
+		f.last[state.ID] = state
+	}
+
+	return f
+}
+
+// SetChanged implements dispatchers.Observer
+func (f *WatchFeed) SetChanged(state *sets.State) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var previous []*sets.Endpoint
+	if last, ok := f.last[state.ID]; ok {
+		previous = last.Endpoints
+	}
+
+	f.last[state.ID] = state
+
+	diff := newWatchDiff(f.c.Revision(), state, previous)
+
+	f.history = append(f.history, diff)
+	if len(f.history) > watchHistory {
+		f.history = f.history[len(f.history)-watchHistory:]
+	}
+
+	for ch := range f.subscribers {
+		select {
+		case ch <- diff:
+		default:
+			// The watcher is too slow; disconnect it so that it may resume.
+			delete(f.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Exported implements dispatchers.Observer
+func (f *WatchFeed) Exported(duration time.Duration, err error) {}
+
+// Notified implements dispatchers.Observer
+func (f *WatchFeed) Notified(duration time.Duration, err error) {}
+
+// subscribe registers a new watcher.
+// If the given epoch is that of the feed and the diffs since the given revision are all still retained, they are returned for replay; otherwise, a snapshot is returned.
+func (f *WatchFeed) subscribe(epoch string, since uint64, resume bool) (ch chan *watchDiff, snapshot *watchSnapshot, replay []*watchDiff) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ch = make(chan *watchDiff, watchBuffer)
+	f.subscribers[ch] = struct{}{}
+
+	revision := f.c.Revision()
+
+	if resume && epoch == f.epoch && since <= revision && f.retains(since) {
+		for _, diff := range f.history {
+			if diff.Revision > since {
+				replay = append(replay, diff)
+			}
+		}
+
+		return ch, nil, replay
+	}
+
+	snapshot = &watchSnapshot{
+		Revision: revision,
+		Sets:     []*apiSet{},
+	}
+
+	for _, set := range f.c.Sets() {
+		snapshot.Sets = append(snapshot.Sets, newAPISet(f.c, set))
+	}
+
+	return ch, snapshot, nil
+}
+
+// retains indicates whether all diffs after the given revision are retained in the history.
+func (f *WatchFeed) retains(since uint64) bool {
+	if since == f.c.Revision() {
+		return true
+	}
+
+	return len(f.history) > 0 && f.history[0].Revision <= since+1
+}
+
+func (f *WatchFeed) unsubscribe(ch chan *watchDiff) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.subscribers[ch]; ok {
+		delete(f.subscribers, ch)
+		close(ch)
+	}
+}
+
+func newWatchDiff(revision uint64, state *sets.State, previous []*sets.Endpoint) *watchDiff {
+	diff := &watchDiff{
+		Revision: revision,
+		Set:      state.ID,
+		Version:  state.Version,
+		Added:    []*apiEndpoint{},
+		Removed:  []*apiEndpoint{},
+		Updated:  []*apiEndpoint{},
+	}
+
+	added, removed := sets.Diff(previous, state.Endpoints)
+
+	for _, ep := range added {
+		diff.Added = append(diff.Added, newAPIEndpoint(ep))
+	}
+
+	for _, ep := range removed {
+		diff.Removed = append(diff.Removed, newAPIEndpoint(ep))
+	}
+
+	prev := make(map[string]*sets.Endpoint, len(previous))
+	for _, ep := range previous {
+		prev[ep.String()] = ep
+	}
+
+	for _, ep := range state.Endpoints {
+		p, ok := prev[ep.String()]
+		if !ok {
+			continue
+		}
+
+		if p.NotReady != ep.NotReady || p.Drained != ep.Drained || !reflect.DeepEqual(p.Attributes, ep.Attributes) {
+			diff.Updated = append(diff.Updated, newAPIEndpoint(ep))
+		}
+	}
+
+	return diff
+}
+
+// Stream the changes of the dispatcher sets as Server-Sent Events.
+// A "snapshot" event with the complete state is sent first, followed by a "diff" event for each change of a set.
+// The ID of each event is the epoch of the feed and the revision of the dispatcher sets, so a client which reconnects with the Last-Event-ID header (or the since query parameter) receives only the diffs it missed, if they are still retained and the daemon has not restarted since.
+// URL:  /api/v1/watch[?since=<event ID>]
+func (h *Handler) handleAPIWatch(w http.ResponseWriter, r *http.Request) {
+	if h.Feed == nil {
+		writeAPIError(w, http.StatusNotFound, "watch is not available")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeAPIError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	var epoch string
+	var since uint64
+	var resume bool
+
+	if last := r.Header.Get("Last-Event-ID"); last != "" {
+		epoch, since, resume = parseEventID(last)
+	} else if q := r.URL.Query().Get("since"); q != "" {
+		epoch, since, resume = parseEventID(q)
+		if !resume {
+			writeAPIError(w, http.StatusBadRequest, "invalid event ID %q", q)
+			return
+		}
+	}
+
+	ch, snapshot, replay := h.Feed.subscribe(epoch, since, resume)
+	defer h.Feed.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	if snapshot != nil {
+		if err := writeEvent(w, "snapshot", h.Feed.epoch, snapshot.Revision, snapshot); err != nil {
+			return
+		}
+	}
+
+	for _, diff := range replay {
+		if err := writeEvent(w, "diff", h.Feed.epoch, diff.Revision, diff); err != nil {
+			return
+		}
+	}
+
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(watchKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case diff, ok := <-ch:
+			if !ok {
+				return
+			}
+
+			if err := writeEvent(w, "diff", h.Feed.epoch, diff.Revision, diff); err != nil {
+				return
+			}
+		}
+
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event string, epoch string, revision uint64, data interface{}) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %s-%d\nevent: %s\ndata: %s\n\n", epoch, revision, event, body)
+
+	return err
+}
+
+// parseEventID parses an event ID of the form <epoch>-<revision>.
+func parseEventID(s string) (epoch string, revision uint64, ok bool) {
+	i := strings.LastIndex(s, "-")
+	if i <= 0 {
+		return "", 0, false
+	}
+
+	revision, err := strconv.ParseUint(s[i+1:], 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+
+	return s[:i], revision, true
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/CyCoreSystems/dispatchers/v2"
+	"github.com/CyCoreSystems/dispatchers/v2/sets"
+)
+
+func TestWatchFeedResume(t *testing.T) {
+	set := sets.NewStaticSet(1, []*sets.Endpoint{{Address: "10.0.0.1", Port: 5060}})
+
+	c := new(dispatchers.Controller)
+	c.AddSet(set)
+
+	f := NewWatchFeed(c)
+	c.Observer = f
+
+	c.ChangeFunc(&sets.State{ID: 1, Version: 1, Endpoints: []*sets.Endpoint{{Address: "10.0.0.1", Port: 5060}, {Address: "10.0.0.2", Port: 5060}}})
+	c.ChangeFunc(&sets.State{ID: 1, Version: 2, Endpoints: []*sets.Endpoint{{Address: "10.0.0.2", Port: 5060}}})
+
+	ch, snapshot, replay := f.subscribe(f.epoch, 1, true)
+	f.unsubscribe(ch)
+
+	if snapshot != nil {
+		t.Fatal("expected a resumption of the same epoch to replay the missed diffs")
+	}
+
+	if len(replay) != 1 || replay[0].Revision != 2 {
+		t.Fatalf("expected the diff of revision 2 to be replayed, got %v", replay)
+	}
+
+	// After a restart, the revisions start over, so the revision of an earlier epoch must not be trusted.
+	ch, snapshot, replay = f.subscribe("earlier", 1, true)
+	f.unsubscribe(ch)
+
+	if snapshot == nil || len(replay) != 0 {
+		t.Fatalf("expected a snapshot for a different epoch, got %d replayed diffs", len(replay))
+	}
+
+	if snapshot.Revision != 2 {
+		t.Errorf("expected a snapshot of revision 2, got %d", snapshot.Revision)
+	}
+}
+
+func TestParseEventID(t *testing.T) {
+	tests := []struct {
+		id       string
+		epoch    string
+		revision uint64
+		ok       bool
+	}{
+		{"kq3x1z-42", "kq3x1z", 42, true},
+		{"kq3x1z-0", "kq3x1z", 0, true},
+		{"42", "", 0, false},
+		{"-42", "", 0, false},
+		{"kq3x1z-", "", 0, false},
+		{"kq3x1z-x", "", 0, false},
+	}
+
+	for _, tt := range tests {
+		epoch, revision, ok := parseEventID(tt.id)
+		if epoch != tt.epoch || revision != tt.revision || ok != tt.ok {
+			t.Errorf("parseEventID(%q) = %q, %d, %v, want %q, %d, %v", tt.id, epoch, revision, ok, tt.epoch, tt.revision, tt.ok)
+		}
+	}
+}
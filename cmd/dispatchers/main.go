@@ -2,30 +2,80 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/CyCoreSystems/dispatchers/v2"
+	"github.com/CyCoreSystems/dispatchers/v2/api"
 	"github.com/CyCoreSystems/dispatchers/v2/exporter"
+	"github.com/CyCoreSystems/dispatchers/v2/metrics"
 	"github.com/CyCoreSystems/dispatchers/v2/notifier"
 	"github.com/CyCoreSystems/dispatchers/v2/sets"
-	"k8s.io/client-go/informers"
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
 var outputFilename string
+var jsonFilename string
+var yamlFilename string
+var configMapName string
+var configMapKey string
+var configMapFormat string
+var permissionsFilename string
+var dbDriver string
+var dbDSN string
+var dbTable string
+var dbLoadBalancerResources string
+var dbGroups string
+var openSIPSMIURL string
+var openSIPSMIDatagram string
+var permissionsGroups string
+var pjsipFilename string
+var pjsipEndpoints string
+var pjsipAORs bool
+var amiAddr string
+var dmqService string
+var dmqFilename string
+var dmqRPCURL string
+var rtpengineSetDefinitions SetDefinitions
+var rtpengineFilename string
+var execCommand string
+var execArgs stringList
+var signalPIDFile string
+var signalProcess string
+var webhookURLs string
+var webhookDiff bool
+var emitEvents bool
 var rpcPort string
 var rpcHost string
+var rpcURL string
+var overridesFilename string
 var kubeCfg string
 
 var apiAddr string
+var apiTLSCert string
+var apiTLSKey string
+var apiClientCA string
+var apiTokenReview bool
+var apiTokenAudiences string
+var apiAdmins string
+var metricsAddr string
 var legacyEndpoints bool
 
 // KamailioStartupDebounceTimer is the amount of time to wait on startup to
@@ -42,11 +92,50 @@ const KamailioStartupDebounceTimer = time.Minute
 func init() {
 	flag.Var(&setDefinitions, "set", "Dispatcher sets of the form [namespace:]name=index[:port], where index is a number and port is the port number on which SIP is to be signaled to the dispatchers.  May be passed multiple times for multiple sets.")
 	flag.Var(&staticSetDefinitions, "static", "Static dispatcher sets of the form index=host[:port][,host[:port]]..., where index is the dispatcher set number/index and port is the port number on which SIP is to be signaled to the dispatchers.  Multiple hosts may be defined using a comma-separated list.")
-	flag.StringVar(&outputFilename, "o", "/data/kamailio/dispatcher.list", "Output file for dispatcher list")
+	flag.StringVar(&outputFilename, "o", "/data/kamailio/dispatcher.list", "Output file for dispatcher list.  Set to the empty string to disable.")
+	flag.StringVar(&jsonFilename, "json", "", "Output file for a JSON snapshot of the dispatcher sets (defaults to none)")
+	flag.StringVar(&yamlFilename, "yaml", "", "Output file for a YAML snapshot of the dispatcher sets (defaults to none)")
+	flag.StringVar(&configMapName, "configmap", "", "ConfigMap of the form [namespace:]name into which the dispatcher list should be written (defaults to none)")
+	flag.StringVar(&configMapKey, "configmap-key", "dispatcher.list", "Key of the ConfigMap into which the dispatcher list should be written")
+	flag.StringVar(&configMapFormat, "configmap-format", "list", "Format of the dispatcher list written to the ConfigMap: 'list' for the kamailio dispatcher list or 'json' for a JSON snapshot")
+	flag.StringVar(&dbDriver, "db-driver", "", "Driver of a database into which the dispatcher sets should be reconciled: 'mysql' or 'postgres' (defaults to none)")
+	flag.StringVar(&dbDSN, "db-dsn", "", "Data source name of the -db-driver database.  Defaults to the value of DB_DSN.")
+	flag.StringVar(&dbTable, "db-table", "dispatcher", "Table of the -db-driver database into which the dispatcher sets should be reconciled: 'dispatcher' for the kamailio dispatcher table, 'opensips-dispatcher' for the OpenSIPS dispatcher table, 'opensips-load-balancer' for the OpenSIPS load_balancer table, 'lcr' for the kamailio lcr_gw table, or 'drouting' for the kamailio dr_gateways table")
+	flag.StringVar(&dbGroups, "db-groups", "", "Mapping of dispatcher sets to LCR instance IDs (for the lcr table) or gateway types (for the drouting table) of the form index=id[,index=id]...  Unmapped sets use their index as their ID.")
+	flag.StringVar(&dbLoadBalancerResources, "db-lb-resources", "", "Resources of each member of the OpenSIPS load_balancer table, such as 'pstn=32;transc=16'")
+	flag.StringVar(&openSIPSMIURL, "opensips-mi", "", "URL of the OpenSIPS mi_http interface, such as 'http://127.0.0.1:8888/mi'.  If set, OpenSIPS is told to reload through it instead of kamailio.")
+	flag.StringVar(&openSIPSMIDatagram, "opensips-mi-datagram", "", "Address of the OpenSIPS mi_datagram socket of the form [unixgram:]address, such as '127.0.0.1:8080' or 'unixgram:/tmp/opensips.sock'.  If set, OpenSIPS is told to reload through it instead of kamailio.")
+	flag.StringVar(&permissionsFilename, "permissions", "", "Output file for a kamailio permissions address list of the dispatcher sets (defaults to none)")
+	flag.StringVar(&permissionsGroups, "permissions-groups", "", "Mapping of dispatcher sets to permissions groups of the form index=group[,index=group]...  Unmapped sets use their index as their group.")
+	flag.StringVar(&pjsipFilename, "pjsip", "", "Output file for Asterisk PJSIP identify (and, with -pjsip-aors, aor) sections of the dispatcher sets (defaults to none)")
+	flag.StringVar(&pjsipEndpoints, "pjsip-endpoints", "", "Mapping of dispatcher sets to PJSIP endpoints of the form index=endpoint[,index=endpoint]...  Unmapped sets use the endpoint dispatcher-<index>.")
+	flag.BoolVar(&pjsipAORs, "pjsip-aors", false, "Also write an aor section for each PJSIP endpoint, with a static contact for each member of its dispatcher set")
+	flag.StringVar(&amiAddr, "ami", "", "Address of the Asterisk Manager Interface, such as '127.0.0.1:5038', through which Asterisk is told to reload its PJSIP configuration.  The AMI user is taken from AMI_USERNAME and AMI_SECRET.  (defaults to none)")
+	flag.StringVar(&dmqService, "dmq", "", "Kamailio Service of the form [namespace:]name[=port] whose endpoints should be maintained as DMQ peers (defaults to none).  The address of the local Pod is taken from POD_IP.")
+	flag.StringVar(&dmqFilename, "dmq-o", "/data/kamailio/dmq.cfg", "Output file for the DMQ notification peer configuration")
+	flag.StringVar(&dmqRPCURL, "dmq-rpc", "", "URL of kamailio's JSON-RPC HTTP interface, used to remove departed DMQ peers (defaults to none)")
+	flag.Var(&rtpengineSetDefinitions, "rtpengine", "rtpengine sets of the form [namespace:]name=index[:port], where index is the rtpengine set number and port is the name or number of the rtpengine control (ng) port.  May be passed multiple times for multiple sets.")
+	flag.StringVar(&rtpengineFilename, "rtpengine-o", "/data/kamailio/rtpengine.cfg", "Output file for the rtpengine set definitions")
+	flag.StringVar(&execCommand, "exec", "", "Command to run on each change of the dispatcher sets, with a JSON snapshot of the sets on its standard input (defaults to none)")
+	flag.Var(&execArgs, "exec-arg", "Argument to pass to the -exec command.  May be passed multiple times for multiple arguments, in order.")
+	flag.StringVar(&signalPIDFile, "signal-pidfile", "", "PID file of a process to be sent SIGHUP on each change of the dispatcher sets (defaults to none)")
+	flag.StringVar(&signalProcess, "signal-process", "", "Name of a process in the same PID namespace to be sent SIGHUP on each change of the dispatcher sets (defaults to none)")
+	flag.StringVar(&webhookURLs, "webhook", "", "Comma-separated list of URLs to which the state of the dispatcher sets should be POSTed on each change (defaults to none).  Bodies are signed with the value of WEBHOOK_SECRET, if set.")
+	flag.BoolVar(&webhookDiff, "webhook-diff", false, "POST only the changes since the previous notification to the webhooks, instead of the complete state")
+	flag.BoolVar(&emitEvents, "events", false, "Emit Kubernetes Events on the Pod named by POD_NAME when an export or notification fails, and on the source Service when a dispatcher set changes")
 	flag.StringVar(&rpcHost, "h", "127.0.0.1", "Host for kamailio's RPC service")
 	flag.StringVar(&rpcPort, "p", "9998", "Port for kamailio's RPC service")
+	flag.StringVar(&rpcURL, "rpc-url", "", "URL of kamailio's JSON-RPC HTTP interface, such as 'http://127.0.0.1:5060/RPC'.  If set, kamailio is told to reload through it, and must acknowledge the reload, instead of through binrpc.")
+	flag.StringVar(&overridesFilename, "overrides", "/data/kamailio/dispatchers-overrides.json", "File in which manual overrides of the dispatcher sets, made through the web API, are persisted.  Set to the empty string to not persist them.")
 	flag.StringVar(&kubeCfg, "kubecfg", "", "Location of kubecfg file (if not running inside k8s)")
 	flag.StringVar(&apiAddr, "api", "", "Address on which to run web API service.  Example ':8080'. (defaults to not run)")
+	flag.StringVar(&apiTLSCert, "api-tls-cert", "", "PEM certificate file with which to serve the web API service over TLS.  It is reloaded when it changes.  (defaults to plain HTTP)")
+	flag.StringVar(&apiTLSKey, "api-tls-key", "", "PEM key file of the -api-tls-cert certificate")
+	flag.StringVar(&apiClientCA, "api-client-ca", "", "PEM certificate authority file with which TLS client certificates of the web API service are verified.  Clients with verified certificates are authenticated by their common name and organizations.  (defaults to none)")
+	flag.BoolVar(&apiTokenReview, "api-token-review", false, "Authenticate bearer tokens of the web API service, such as ServiceAccount tokens, with the Kubernetes TokenReview API")
+	flag.StringVar(&apiTokenAudiences, "api-token-audiences", "dispatchers", "Comma-separated list of the audiences for which bearer tokens authenticated with the TokenReview API must have been issued.  Set it to the empty string to accept tokens of the Kubernetes API server")
+	flag.StringVar(&apiAdmins, "api-admins", "", "Comma-separated list of the usernames and groups (or certificate common names and organizations) of authenticated clients of the web API service which may make administrative requests (defaults to none)")
+	flag.StringVar(&metricsAddr, "metrics", "", "Address on which to serve Prometheus metrics at /metrics, separately from the web API service.  Example ':9090'. (defaults to not run)")
 	flag.BoolVar(&legacyEndpoints, "legacy-endpoints", false, "Use legacy Endpoints instead of EndpointSlices, for Kubernetes earlier than v1.21")
 }
 
@@ -82,30 +171,197 @@ func run() (err error) {
 		return fmt.Errorf("failed to create kubernetes client: %w", err)
 	}
 
-	exp, err := exporter.NewFileExporter(outputFilename, "")
+	var exporters dispatchers.MultiExporter
+
+	if outputFilename != "" {
+		exp, err := exporter.NewFileExporter(outputFilename, "")
+		if err != nil {
+			return fmt.Errorf("failed to construct file exporter: %w", err)
+		}
+
+		exporters = append(exporters, exp)
+	}
+
+	var snapshotExporters []*exporter.SnapshotExporter
+
+	if jsonFilename != "" {
+		jsonExp, err := exporter.NewJSONExporter(jsonFilename, snapshotSource())
+		if err != nil {
+			return fmt.Errorf("failed to construct JSON exporter: %w", err)
+		}
+
+		exporters = append(exporters, jsonExp)
+		snapshotExporters = append(snapshotExporters, jsonExp)
+	}
+
+	if yamlFilename != "" {
+		yamlExp, err := exporter.NewYAMLExporter(yamlFilename, snapshotSource())
+		if err != nil {
+			return fmt.Errorf("failed to construct YAML exporter: %w", err)
+		}
+
+		exporters = append(exporters, yamlExp)
+		snapshotExporters = append(snapshotExporters, yamlExp)
+	}
+
+	if configMapName != "" {
+		cmExp, err := newConfigMapExporter(kc)
+		if err != nil {
+			return fmt.Errorf("failed to construct ConfigMap exporter: %w", err)
+		}
+
+		exporters = append(exporters, cmExp)
+	}
+
+	if dbDriver != "" {
+		sqlExp, err := newSQLExporter()
+		if err != nil {
+			return fmt.Errorf("failed to construct SQL exporter: %w", err)
+		}
+
+		exporters = append(exporters, sqlExp)
+	}
+
+	reload, err := reloadNotifier()
 	if err != nil {
-		return fmt.Errorf("failed to construct file exporter: %w", err)
+		return fmt.Errorf("failed to construct reload notifier: %w", err)
+	}
+
+	notifiers := dispatchers.MultiNotifier{reload}
+
+	if permissionsFilename != "" {
+		groups, err := parseGroupMap(permissionsGroups)
+		if err != nil {
+			return fmt.Errorf("failed to parse permissions groups: %w", err)
+		}
+
+		permExp, err := exporter.NewAddressListExporter(permissionsFilename, groups, false)
+		if err != nil {
+			return fmt.Errorf("failed to construct permissions exporter: %w", err)
+		}
+
+		exporters = append(exporters, permExp)
+
+		notifiers = append(notifiers, kamailioNotifier(notifier.PermissionsAddressReload))
+	}
+
+	if pjsipFilename != "" {
+		endpoints, err := parseEndpointMap(pjsipEndpoints)
+		if err != nil {
+			return fmt.Errorf("failed to parse PJSIP endpoints: %w", err)
+		}
+
+		pjsipExp, err := exporter.NewPJSIPExporter(pjsipFilename, endpoints, pjsipAORs)
+		if err != nil {
+			return fmt.Errorf("failed to construct PJSIP exporter: %w", err)
+		}
+
+		exporters = append(exporters, pjsipExp)
+	}
+
+	if amiAddr != "" {
+		notifiers = append(notifiers, &notifier.AMINotifier{
+			Address:  amiAddr,
+			Username: os.Getenv("AMI_USERNAME"),
+			Secret:   os.Getenv("AMI_SECRET"),
+		})
+	}
+
+	var execNotifier *notifier.ExecNotifier
+
+	if execCommand != "" {
+		execNotifier = &notifier.ExecNotifier{
+			Command: execCommand,
+			Args:    execArgs,
+		}
+
+		notifiers = append(notifiers, execNotifier)
+	}
+
+	if signalPIDFile != "" || signalProcess != "" {
+		notifiers = append(notifiers, &notifier.SignalNotifier{
+			PIDFile:     signalPIDFile,
+			ProcessName: signalProcess,
+		})
+	}
+
+	var webhookNotifier *notifier.WebhookNotifier
+
+	if webhookURLs != "" {
+		hook := &notifier.WebhookNotifier{
+			Diff: webhookDiff,
+		}
+
+		for _, u := range strings.Split(webhookURLs, ",") {
+			hook.Endpoints = append(hook.Endpoints, &notifier.WebhookEndpoint{
+				URL:    u,
+				Secret: os.Getenv("WEBHOOK_SECRET"),
+			})
+		}
+
+		notifiers = append(notifiers, hook)
+		webhookNotifier = hook
+	}
+
+	overrides := &dispatchers.Overrides{
+		Filename: overridesFilename,
+	}
+
+	if err = overrides.Load(); err != nil {
+		return fmt.Errorf("failed to load overrides: %w", err)
 	}
 
 	controller := &dispatchers.Controller{
-		Exporter: exp,
-		Notifier: &notifier.BinRPCNotifier{
-			Host: rpcHost,
-			Port: rpcPort,
-		},
-		Logger: log.Default(),
+		Exporter:  exporters,
+		Notifier:  notifiers,
+		Overrides: overrides,
+		Logger:    log.Default(),
 	}
 
-	informerFactory := informers.NewSharedInformerFactory(kc, 10*time.Minute)
+	if execNotifier != nil {
+		execNotifier.Revision = controller.Revision
+	}
 
-	for _, v := range setDefinitions.list {
-		var ds sets.DispatcherSet
+	if webhookNotifier != nil {
+		webhookNotifier.Revision = controller.Revision
+	}
 
-		if legacyEndpoints {
-			ds, err = sets.NewLegacyKubernetesSet(ctx, informerFactory, v.id, v.namespace, v.name, v.port)
-		} else {
-			ds, err = sets.NewKubernetesSet(ctx, informerFactory, v.id, v.namespace, v.name, v.port)
+	for _, e := range snapshotExporters {
+		e.Revision = controller.Revision
+	}
+
+	collector := metrics.New(controller)
+
+	observers := dispatchers.MultiObserver{collector}
+
+	if emitEvents {
+		events, err := newEventNotifier(kc)
+		if err != nil {
+			return fmt.Errorf("failed to construct event notifier: %w", err)
 		}
+		defer events.Close()
+
+		observers = append(observers, events)
+	}
+
+	var feed *api.WatchFeed
+
+	if apiAddr != "" {
+		feed = api.NewWatchFeed(controller)
+
+		observers = append(observers, feed)
+	}
+
+	controller.Observer = observers
+
+	watcher := &sets.KubernetesWatcher{
+		Client:         kc,
+		Legacy:         legacyEndpoints,
+		SelectServices: true,
+	}
+
+	for _, v := range setDefinitions.list {
+		ds, err := watcher.NewSet(v.id, v.namespace, v.name, v.port)
 		if err != nil {
 			return fmt.Errorf("failed to create dispatcher set %s: %w", v.String(), err)
 		}
@@ -117,6 +373,114 @@ func run() (err error) {
 		controller.AddSet(sets.NewStaticSet(vs.id, vs.members))
 	}
 
+	var dmqController *dispatchers.Controller
+
+	if dmqService != "" {
+		if dmqController, err = newDMQController(watcher); err != nil {
+			return fmt.Errorf("failed to construct DMQ peer management: %w", err)
+		}
+	}
+
+	var rtpengineController *dispatchers.Controller
+
+	if len(rtpengineSetDefinitions.list) > 0 {
+		if rtpengineController, err = newRTPEngineController(watcher); err != nil {
+			return fmt.Errorf("failed to construct rtpengine set management: %w", err)
+		}
+	}
+
+	// The servers are started before the dispatcher sets are synced, so that the health and readiness probes are answered (as not ready) while the Controller waits for them.
+	// Servers report their failures on serverErrs and are waited for on shutdown, so that they may complete their requests.
+	serverErrs := make(chan error, 2)
+
+	var servers sync.WaitGroup
+	defer func() {
+		cancel()
+		servers.Wait()
+	}()
+
+	runServer := func(name string, srv *api.Server) {
+		servers.Add(1)
+
+		go func() {
+			defer servers.Done()
+
+			if err := srv.Run(ctx); err != nil {
+				serverErrs <- fmt.Errorf("%s failed: %w", name, err)
+			}
+		}()
+	}
+
+	// Run HTTP API service
+	if apiAddr != "" {
+		handler := &api.Handler{
+			Controller: controller,
+			Metrics:    collector,
+			Feed:       feed,
+			Auth: &api.Auth{
+				ReadToken:          os.Getenv("API_READ_TOKEN"),
+				AdminToken:         os.Getenv("API_ADMIN_TOKEN"),
+				ClientCertificates: apiClientCA != "",
+			},
+		}
+
+		if apiTokenReview {
+			handler.Auth.TokenReviewer = kc
+
+			if apiTokenAudiences != "" {
+				handler.Auth.Audiences = strings.Split(apiTokenAudiences, ",")
+			}
+		}
+
+		if apiAdmins != "" {
+			handler.Auth.Admins = strings.Split(apiAdmins, ",")
+		}
+
+		srv := &api.Server{
+			Addr:    apiAddr,
+			Handler: handler,
+		}
+
+		if apiTLSCert != "" || apiTLSKey != "" || apiClientCA != "" {
+			if srv.TLSConfig, err = api.NewTLSConfig(apiTLSCert, apiTLSKey, apiClientCA); err != nil {
+				return fmt.Errorf("failed to configure TLS for the web API service: %w", err)
+			}
+		}
+
+		runServer("web API service", srv)
+	}
+
+	// Run separate metrics service
+	if metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", collector)
+
+		runServer("metrics service", &api.Server{
+			Addr:    metricsAddr,
+			Handler: mux,
+		})
+	}
+
+	if err = watcher.Start(ctx); err != nil {
+		return fmt.Errorf("failed to start kubernetes watcher: %w", err)
+	}
+
+	if dmqController != nil {
+		if err = dmqController.Start(ctx); err != nil {
+			return fmt.Errorf("failed to start DMQ peer management: %w", err)
+		}
+	}
+
+	if rtpengineController != nil {
+		if err = rtpengineController.Start(ctx); err != nil {
+			return fmt.Errorf("failed to start rtpengine set management: %w", err)
+		}
+	}
+
+	if err = controller.Start(ctx); err != nil {
+		return fmt.Errorf("failed to start controller: %w", err)
+	}
+
 	// NB: Since binrpc is over UDP and returns no data,
 	// we have no idea whether the kamailio instance is actually up and
 	// receiving the notification.  Therefore, we send a notify again a little
@@ -127,17 +491,12 @@ func run() (err error) {
 		}
 	})
 
-	// Run HTTP API service
-	if apiAddr != "" {
-		svc := &httpService{controller}
-
-		go svc.Run(ctx, apiAddr)
-	}
-
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case err = <-serverErrs:
+			return err
 		case <-time.After(time.Minute):
 			log.Println("current sets:")
 			for _, set := range controller.CurrentState() {
@@ -147,6 +506,381 @@ func run() (err error) {
 	}
 }
 
+// stringList is a flag.Value which collects each of its values.
+type stringList []string
+
+// String implements flag.Value
+func (l *stringList) String() string {
+	return strings.Join(*l, " ")
+}
+
+// Set implements flag.Value
+func (l *stringList) Set(raw string) error {
+	*l = append(*l, raw)
+	return nil
+}
+
+// reloadNotifier returns the Notifier which tells the SIP proxy to reload the dispatcher sets:  OpenSIPS, using MI, if an MI interface was given, and kamailio otherwise.
+// Kamailio reloads the module of the -db-table table, if it is the lcr or drouting table, in addition to its dispatcher module, if the dispatcher list is written.
+func reloadNotifier() (dispatchers.Notifier, error) {
+	if openSIPSMIURL == "" && openSIPSMIDatagram == "" {
+		var method string
+
+		switch {
+		case dbDriver != "" && dbTable == "lcr":
+			method = notifier.LCRReload
+		case dbDriver != "" && dbTable == "drouting":
+			method = notifier.DRoutingReload
+		default:
+			return kamailioNotifier(notifier.DispatcherReload), nil
+		}
+
+		if outputFilename == "" {
+			return kamailioNotifier(method), nil
+		}
+
+		return dispatchers.MultiNotifier{
+			kamailioNotifier(notifier.DispatcherReload),
+			kamailioNotifier(method),
+		}, nil
+	}
+
+	if openSIPSMIURL != "" && openSIPSMIDatagram != "" {
+		return nil, fmt.Errorf("only one of -opensips-mi and -opensips-mi-datagram may be set")
+	}
+
+	command := notifier.OpenSIPSDispatcherReload
+	if dbTable == "opensips-load-balancer" {
+		command = notifier.OpenSIPSLoadBalancerReload
+	}
+
+	if openSIPSMIURL != "" {
+		return &notifier.MIHTTPNotifier{
+			URL:     openSIPSMIURL,
+			Command: command,
+		}, nil
+	}
+
+	network := "udp"
+	addr := openSIPSMIDatagram
+
+	if strings.HasPrefix(addr, "unixgram:") {
+		network = "unixgram"
+		addr = strings.TrimPrefix(addr, "unixgram:")
+	}
+
+	return &notifier.MIDatagramNotifier{
+		Network: network,
+		Address: addr,
+		Command: command,
+	}, nil
+}
+
+// kamailioNotifier returns a Notifier which tells kamailio to invoke the given RPC method, using JSON-RPC if a URL was given and binrpc otherwise.
+func kamailioNotifier(method string) dispatchers.Notifier {
+	if rpcURL != "" {
+		return &notifier.JSONRPCNotifier{
+			URL:    rpcURL,
+			Method: method,
+		}
+	}
+
+	return &notifier.BinRPCNotifier{
+		Host:   rpcHost,
+		Port:   rpcPort,
+		Method: method,
+	}
+}
+
+// newSQLExporter creates an SQLExporter which reconciles the dispatcher sets into the -db-table table of the -db-driver database.
+func newSQLExporter() (*exporter.SQLExporter, error) {
+	var dialect exporter.SQLDialect
+
+	switch dbDriver {
+	case "mysql":
+		dialect = exporter.MySQL
+	case "postgres":
+		dialect = exporter.PostgreSQL
+	default:
+		return nil, fmt.Errorf("unhandled database driver %q", dbDriver)
+	}
+
+	var table *exporter.SQLTable
+
+	switch dbTable {
+	case "dispatcher":
+		table = exporter.KamailioDispatcherTable
+	case "opensips-dispatcher":
+		table = exporter.OpenSIPSDispatcherTable
+	case "opensips-load-balancer":
+		if dbLoadBalancerResources == "" {
+			return nil, fmt.Errorf("-db-lb-resources must be set for the OpenSIPS load_balancer table")
+		}
+
+		table = exporter.OpenSIPSLoadBalancerTable(dbLoadBalancerResources)
+	case "lcr", "drouting":
+		groups, err := parseGroupMap(dbGroups)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse database groups: %w", err)
+		}
+
+		if dbTable == "lcr" {
+			table = exporter.KamailioLCRGatewayTable(groups)
+		} else {
+			table = exporter.KamailioDRoutingGatewayTable(groups)
+		}
+	default:
+		return nil, fmt.Errorf("unhandled database table %q", dbTable)
+	}
+
+	dsn := dbDSN
+	if dsn == "" {
+		dsn = os.Getenv("DB_DSN")
+	}
+
+	db, err := sql.Open(dbDriver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s database: %w", dbDriver, err)
+	}
+
+	return exporter.NewSQLExporter(db, dialect, table)
+}
+
+func newEventNotifier(kc kubernetes.Interface) (*notifier.EventNotifier, error) {
+	if os.Getenv("POD_NAME") == "" || os.Getenv("POD_NAMESPACE") == "" {
+		return nil, fmt.Errorf("POD_NAME and POD_NAMESPACE must be set to emit events")
+	}
+
+	pod := &v1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Namespace:  os.Getenv("POD_NAMESPACE"),
+		Name:       os.Getenv("POD_NAME"),
+		UID:        types.UID(os.Getenv("POD_UID")),
+	}
+
+	services := make(map[int]*v1.ObjectReference)
+
+	for _, v := range setDefinitions.list {
+		services[v.id] = &v1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "Service",
+			Namespace:  v.namespace,
+			Name:       v.name,
+		}
+	}
+
+	return notifier.NewEventNotifier(kc, pod, services)
+}
+
+// newDMQController creates a Controller which maintains kamailio's DMQ peers from the endpoints of the kamailio Service.
+func newDMQController(watcher *sets.KubernetesWatcher) (*dispatchers.Controller, error) {
+	ns := "default"
+	if os.Getenv("POD_NAMESPACE") != "" {
+		ns = os.Getenv("POD_NAMESPACE")
+	}
+
+	name := dmqService
+	port := "5060"
+
+	if pieces := strings.SplitN(name, "=", 2); len(pieces) == 2 {
+		name = pieces[0]
+		port = pieces[1]
+	}
+
+	if pieces := strings.SplitN(name, ":", 2); len(pieces) == 2 {
+		ns = pieces[0]
+		name = pieces[1]
+	}
+
+	local := os.Getenv("POD_IP")
+	if local == "" {
+		log.Println("WARNING: POD_IP is not set; the local kamailio will be included in its own DMQ peers")
+	}
+
+	exp, err := exporter.NewDMQExporter(dmqFilename, local)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct DMQ exporter: %w", err)
+	}
+
+	controller := &dispatchers.Controller{
+		Exporter: exp,
+		Logger:   log.Default(),
+	}
+
+	if dmqRPCURL != "" {
+		controller.Notifier = &notifier.DMQNotifier{
+			URL:   dmqRPCURL,
+			Local: local,
+		}
+	}
+
+	ds, err := watcher.NewSet(0, ns, name, port)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DMQ set %s:%s: %w", ns, name, err)
+	}
+
+	controller.AddSet(ds)
+
+	return controller, nil
+}
+
+// newRTPEngineController creates a Controller which writes the rtpengine sets as rtpengine control sockets of kamailio's rtpengine module.
+func newRTPEngineController(watcher *sets.KubernetesWatcher) (*dispatchers.Controller, error) {
+	exp, err := exporter.NewFileExporter(rtpengineFilename, exporter.RTPEngineSocketTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct rtpengine exporter: %w", err)
+	}
+
+	controller := &dispatchers.Controller{
+		Exporter: exp,
+		Logger:   log.Default(),
+	}
+
+	for _, v := range rtpengineSetDefinitions.list {
+		ds, err := watcher.NewSet(v.id, v.namespace, v.name, v.port)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create rtpengine set %s: %w", v.String(), err)
+		}
+
+		controller.AddSet(ds)
+	}
+
+	return controller, nil
+}
+
+func newConfigMapExporter(kc kubernetes.Interface) (*exporter.ConfigMapExporter, error) {
+	ns := "default"
+	if os.Getenv("POD_NAMESPACE") != "" {
+		ns = os.Getenv("POD_NAMESPACE")
+	}
+
+	name := configMapName
+	if pieces := strings.SplitN(configMapName, ":", 2); len(pieces) == 2 {
+		ns = pieces[0]
+		name = pieces[1]
+	}
+
+	var render exporter.Renderer
+
+	switch configMapFormat {
+	case "list":
+	case "json":
+		render = exporter.JSONRenderer(snapshotSource())
+	default:
+		return nil, fmt.Errorf("unhandled ConfigMap format %q", configMapFormat)
+	}
+
+	var owners []metav1.OwnerReference
+
+	owner, err := podOwnerReference(kc, ns)
+	if err != nil {
+		log.Println("WARNING: the ConfigMap will not be owned by the dispatchers Pod:", err)
+	} else {
+		owners = append(owners, *owner)
+	}
+
+	return exporter.NewConfigMapExporter(kc, ns, name, configMapKey, render, owners...)
+}
+
+// podOwnerReference returns an owner reference to the Pod named by POD_NAME, which must be in the given namespace, looking up its UID if POD_UID is not set.
+func podOwnerReference(kc kubernetes.Interface, namespace string) (*metav1.OwnerReference, error) {
+	name := os.Getenv("POD_NAME")
+	if name == "" || os.Getenv("POD_NAMESPACE") == "" {
+		return nil, fmt.Errorf("POD_NAME and POD_NAMESPACE must be set")
+	}
+
+	if os.Getenv("POD_NAMESPACE") != namespace {
+		return nil, fmt.Errorf("the Pod is in namespace %s rather than %s, and owner references may not cross namespaces", os.Getenv("POD_NAMESPACE"), namespace)
+	}
+
+	uid := types.UID(os.Getenv("POD_UID"))
+
+	if uid == "" {
+		ctx, cancel := context.WithTimeout(context.Background(), exporter.ConfigMapTimeout)
+		defer cancel()
+
+		pod, err := kc.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up the UID of Pod %s/%s: %w", namespace, name, err)
+		}
+
+		uid = pod.UID
+	}
+
+	return &metav1.OwnerReference{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Name:       name,
+		UID:        uid,
+	}, nil
+}
+
+// parseGroupMap parses a mapping of dispatcher set indices to group IDs of the form index=group[,index=group]...
+func parseGroupMap(raw string) (map[int]int, error) {
+	groups := make(map[int]int)
+
+	if raw == "" {
+		return groups, nil
+	}
+
+	for _, pair := range strings.Split(raw, ",") {
+		pieces := strings.Split(pair, "=")
+		if len(pieces) != 2 {
+			return nil, fmt.Errorf("failed to parse %s as the form index=group", pair)
+		}
+
+		id, err := strconv.Atoi(pieces[0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse index %s as an integer: %w", pieces[0], err)
+		}
+
+		group, err := strconv.Atoi(pieces[1])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse group %s as an integer: %w", pieces[1], err)
+		}
+
+		groups[id] = group
+	}
+
+	return groups, nil
+}
+
+// parseEndpointMap parses a mapping of dispatcher sets to PJSIP endpoint names of the form index=endpoint[,index=endpoint]...
+func parseEndpointMap(raw string) (map[int]string, error) {
+	endpoints := make(map[int]string)
+
+	if raw == "" {
+		return endpoints, nil
+	}
+
+	for _, pair := range strings.Split(raw, ",") {
+		pieces := strings.Split(pair, "=")
+		if len(pieces) != 2 || pieces[1] == "" {
+			return nil, fmt.Errorf("failed to parse %s as the form index=endpoint", pair)
+		}
+
+		id, err := strconv.Atoi(pieces[0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse index %s as an integer: %w", pieces[0], err)
+		}
+
+		endpoints[id] = pieces[1]
+	}
+
+	return endpoints, nil
+}
+
+// snapshotSource describes this daemon as the source of exported snapshots.
+func snapshotSource() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "dispatchers"
+	}
+
+	return "dispatchers@" + hostname
+}
+
 func newStopContext() (context.Context, context.CancelFunc) {
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -1,12 +1,25 @@
 package dispatchers
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/CyCoreSystems/dispatchers/v2/sets"
+	"inet.af/netaddr"
+	"k8s.io/client-go/tools/cache"
 )
 
+// OverrideExpiryInterval is the interval at which a started Controller removes expired Overrides.
+var OverrideExpiryInterval = time.Second
+
+// DefaultSyncTimeout is the maximum amount of time Start waits for the dispatcher sets to sync, if the Controller specifies no SyncTimeout.
+const DefaultSyncTimeout = time.Minute
+
 // An Exporter handles the export of the set of dispatcher sets.
 type Exporter interface {
 	Export([]*sets.State) error
@@ -17,17 +30,146 @@ type Notifier interface {
 	Notify([]*sets.State) error
 }
 
-// Controller manages the processing of dispatcher sets
+// An Observer is informed of the activity of a Controller.
+type Observer interface {
+	// SetChanged is called whenever the membership of a dispatcher set changes.
+	// Calls are serialized, and during each call, the Revision of the Controller is that of the change.
+	SetChanged(state *sets.State)
+
+	// Exported is called after each export, with its duration and result.
+	Exported(duration time.Duration, err error)
+
+	// Notified is called after each notification, with its duration and result.
+	Notified(duration time.Duration, err error)
+}
+
+// MultiExporter is an Exporter which exports to each of a list of Exporters in turn.
+type MultiExporter []Exporter
+
+// Export implements Exporter
+func (m MultiExporter) Export(states []*sets.State) error {
+	var errs []string
+
+	for _, e := range m {
+		if err := e.Export(states); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%d of %d exports failed: %s", len(errs), len(m), strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// MultiNotifier is a Notifier which notifies each of a list of Notifiers in turn.
+type MultiNotifier []Notifier
+
+// Notify implements Notifier
+func (m MultiNotifier) Notify(states []*sets.State) error {
+	var errs []string
+
+	for _, n := range m {
+		if err := n.Notify(states); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%d of %d notifications failed: %s", len(errs), len(m), strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// MultiObserver is an Observer which informs each of a list of Observers in turn.
+type MultiObserver []Observer
+
+// SetChanged implements Observer
+func (m MultiObserver) SetChanged(state *sets.State) {
+	for _, o := range m {
+		o.SetChanged(state)
+	}
+}
+
+// Exported implements Observer
+func (m MultiObserver) Exported(duration time.Duration, err error) {
+	for _, o := range m {
+		o.Exported(duration, err)
+	}
+}
+
+// Notified implements Observer
+func (m MultiObserver) Notified(duration time.Duration, err error) {
+	for _, o := range m {
+		o.Notified(duration, err)
+	}
+}
+
+// Controller manages the processing of dispatcher sets.
+// Once Start is called, changes to the dispatcher sets are not exported until their initial membership has been loaded; from then on, they are exported in the background until the context given to Start is cancelled.
+// A Controller which is never started exports each change as it is made, before ChangeFunc returns.
 type Controller struct{
 	Exporter Exporter
 	Notifier Notifier
+	Observer Observer
 	Logger *log.Logger
 
+	// Overrides are optional manual overrides of the membership of the dispatcher sets, which are layered on top of their discovered membership.
+	Overrides *Overrides
+
+	// SyncTimeout is the maximum amount of time Start waits for the dispatcher sets to sync.  If not specified, DefaultSyncTimeout is used.
+	SyncTimeout time.Duration
+
+	// starting is set to 1 once Start has been called.
+	starting uint32
+
+	// started is set to 1 once the initial sync has completed.
+	started uint32
+
 	sets []sets.DispatcherSet
 
+	// revision is incremented on each change of a dispatcher set.
+	revision uint64
+
+	status   Status
+	statusMu sync.Mutex
+
+	// changeMu serializes the recording of changes, so that observers are informed of them in the order of their revisions.
+	changeMu sync.Mutex
+
+	// processMu serializes the processing of changes, so that exports and notifications are never concurrent.
+	processMu sync.Mutex
+
+	// pending signals the processing loop, started by Start, that changes are waiting to be processed.
+	// It is buffered, so that changes which arrive during the processing of an earlier one are coalesced into a single further export.
+	pending chan struct{}
+
 	mu sync.RWMutex
 }
 
+// Status describes the results of the exports and notifications of a Controller.
+type Status struct {
+	// Exported indicates that at least one export has succeeded.
+	Exported bool
+
+	// Notified indicates that at least one notification has succeeded.
+	Notified bool
+
+	// LastExport is the time of the most recent export.
+	LastExport time.Time
+
+	// ExportError is the error of the most recent export, if it failed.
+	ExportError error
+
+	// LastNotify is the time of the most recent notification.
+	LastNotify time.Time
+
+	// NotifyError is the error of the most recent notification, if it failed.
+	NotifyError error
+}
+
 // AddSet adds a DispatcherSet to the Controller
 func (c *Controller) AddSet(set sets.DispatcherSet) {
 	c.mu.Lock()
@@ -39,56 +181,371 @@ func (c *Controller) AddSet(set sets.DispatcherSet) {
 	c.mu.Unlock()
 }
 
+// CurrentState returns a deep copy of the current snapshot of each of the dispatcher sets of the Controller, with any Overrides applied, which may be modified freely.
 func (c *Controller) CurrentState() (currentState []*sets.State) {
 
 	c.mu.RLock()
 	for _, s := range c.sets {
-		currentState = append(currentState, s.State())
+		currentState = append(currentState, c.Overrides.Apply(s.State().Copy(), nil))
 	}
 	c.mu.RUnlock()
 
 	return currentState
 }
 
+// Lookup returns the dispatcher sets which contain the given address and, if port is non-zero, port.
+// Each is returned as a copy of the State of the set containing only its matching members.
+func (c *Controller) Lookup(address string, port uint32) []*sets.State {
+	return c.lookup(func(set sets.Lookuper) []*sets.Endpoint {
+		return set.Lookup(address, port)
+	}, func(ep *sets.Endpoint) bool {
+		return normalizeAddress(ep.Address) == normalizeAddress(address) && (port == 0 || ep.Port == port)
+	})
+}
+
+// LookupPrefix returns the dispatcher sets which contain members within the given prefix (CIDR).
+// Each is returned as a copy of the State of the set containing only its matching members.
+func (c *Controller) LookupPrefix(prefix netaddr.IPPrefix) []*sets.State {
+	return c.lookup(func(set sets.Lookuper) []*sets.Endpoint {
+		return set.LookupPrefix(prefix)
+	}, func(ep *sets.Endpoint) bool {
+		ip, ok := sets.ParseIP(ep.Address)
+		return ok && prefix.Contains(ip)
+	})
+}
+
+// lookup returns the matching members of each set, found with match, with any Overrides applied.
+// Members added by Overrides are matched with include.
+// Sets which do not implement sets.Lookuper are indexed for the lookup.
+func (c *Controller) lookup(match func(sets.Lookuper) []*sets.Endpoint, include func(*sets.Endpoint) bool) (matches []*sets.State) {
+	for _, set := range c.Sets() {
+		state := set.State()
+
+		l, ok := set.(sets.Lookuper)
+		if !ok {
+			l = sets.IndexState(state)
+		}
+
+		matched := c.Overrides.Apply((&sets.State{
+			ID:        state.ID,
+			Version:   state.Version,
+			Endpoints: match(l),
+		}).Copy(), include)
+
+		if len(matched.Endpoints) > 0 {
+			matches = append(matches, matched)
+		}
+	}
+
+	return matches
+}
+
+// SetOverride adds or replaces a manual override of a member of a dispatcher set; the resulting change of the set is exported asynchronously if the Controller has been started.
+func (c *Controller) SetOverride(ov *Override) error {
+	if c.Overrides == nil {
+		return fmt.Errorf("overrides are not enabled")
+	}
+
+	set := c.findSet(ov.Set)
+	if set == nil {
+		return fmt.Errorf("set %d does not exist", ov.Set)
+	}
+
+	if err := c.Overrides.Set(ov); err != nil {
+		return err
+	}
+
+	c.ChangeFunc(set.State())
+
+	return nil
+}
+
+// RemoveOverride removes the manual override of a member of a dispatcher set, if there is one; the resulting change of the set is exported asynchronously if the Controller has been started.
+func (c *Controller) RemoveOverride(setID int, address string, port uint32) (bool, error) {
+	if c.Overrides == nil {
+		return false, fmt.Errorf("overrides are not enabled")
+	}
+
+	removed, err := c.Overrides.Remove(setID, address, port)
+	if err != nil || !removed {
+		return removed, err
+	}
+
+	if set := c.findSet(setID); set != nil {
+		c.ChangeFunc(set.State())
+	}
+
+	return true, nil
+}
+
+// expireOverrides periodically removes the Overrides which have expired, processing the resulting changes of their sets.
+func (c *Controller) expireOverrides(ctx context.Context) {
+	ticker := time.NewTicker(OverrideExpiryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			changed, err := c.Overrides.Expire(now)
+			if err != nil && c.Logger != nil {
+				c.Logger.Println("failed to persist expired overrides:", err)
+			}
+
+			for _, id := range changed {
+				if set := c.findSet(id); set != nil {
+					c.ChangeFunc(set.State())
+				}
+			}
+		}
+	}
+}
+
+func (c *Controller) findSet(id int) sets.DispatcherSet {
+	for _, set := range c.Sets() {
+		if set.State().ID == id {
+			return set
+		}
+	}
+
+	return nil
+}
+
+// Revision returns the current revision of the dispatcher sets, which is incremented whenever any set changes.
+func (c *Controller) Revision() uint64 {
+	return atomic.LoadUint64(&c.revision)
+}
+
+// Sets returns the DispatcherSets of the Controller.
+func (c *Controller) Sets() []sets.DispatcherSet {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return append([]sets.DispatcherSet(nil), c.sets...)
+}
+
+// Status returns the results of the exports and notifications of the Controller.
+func (c *Controller) Status() Status {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	return c.status
+}
+
+// Synced indicates whether the initial membership of all of the dispatcher sets of the Controller has been loaded.
+func (c *Controller) Synced() bool {
+	for _, s := range c.Sets() {
+		if !sets.HasSynced(s) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Ready returns nil once the dispatcher sets have all been synced, an export has succeeded, and a notification has succeeded, so that the consumer of the exports may be expected to have loaded a populated set of dispatchers.
+// Otherwise, it returns an error describing what is still outstanding.
+// A Controller without an Exporter or Notifier does not wait for it.
+func (c *Controller) Ready() error {
+	if !c.Synced() {
+		return fmt.Errorf("dispatcher sets have not been synced")
+	}
+
+	status := c.Status()
+
+	if c.Exporter != nil && !status.Exported {
+		if status.ExportError != nil {
+			return fmt.Errorf("no export has succeeded: %w", status.ExportError)
+		}
+
+		return fmt.Errorf("no export has succeeded")
+	}
+
+	if c.Notifier != nil && !status.Notified {
+		if status.NotifyError != nil {
+			return fmt.Errorf("no notification has succeeded: %w", status.NotifyError)
+		}
+
+		return fmt.Errorf("no notification has succeeded")
+	}
+
+	return nil
+}
+
+// Start waits for the initial membership of all of the dispatcher sets of the Controller to be loaded, then performs an initial export and notification of the complete state.
+// Until Start completes, changes to the dispatcher sets are not exported, so that partial state is never exported while the sets are loading.
+// Failures of the initial export and notification are logged rather than returned; an error is only returned if the sets do not sync within the SyncTimeout or the context is cancelled.
+func (c *Controller) Start(ctx context.Context) error {
+	atomic.StoreUint32(&c.starting, 1)
+
+	timeout := c.SyncTimeout
+	if timeout == 0 {
+		timeout = DefaultSyncTimeout
+	}
+
+	syncCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var synced []cache.InformerSynced
+
+	for _, s := range c.Sets() {
+		if syncer, ok := s.(sets.Syncer); ok {
+			synced = append(synced, syncer.HasSynced)
+		}
+	}
+
+	if !cache.WaitForCacheSync(syncCtx.Done(), synced...) {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		return fmt.Errorf("dispatcher sets did not sync within %s", timeout)
+	}
+
+	c.pending = make(chan struct{}, 1)
+
+	atomic.StoreUint32(&c.started, 1)
+
+	if c.Overrides != nil {
+		go c.expireOverrides(ctx)
+	}
+
+	c.process()
+
+	go c.processChanges(ctx)
+
+	return nil
+}
+
+// processChanges processes the pending changes until the context is cancelled.
+func (c *Controller) processChanges(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.pending:
+			c.process()
+		}
+	}
+}
+
 // Export tells the Controller to export its current dispatcher sets
 func (c *Controller) Export() error {
+	c.processMu.Lock()
+	defer c.processMu.Unlock()
+
+	return c.export(c.CurrentState())
+}
+
+// Notify tells the Controller to send a notification to its notifier
+func (c *Controller) Notify() error {
+	c.processMu.Lock()
+	defer c.processMu.Unlock()
+
+	return c.notify(c.CurrentState())
+}
+
+func (c *Controller) export(currentState []*sets.State) error {
 	if c.Exporter == nil {
 		return nil
 	}
 
-	return c.Exporter.Export(c.CurrentState())
+	started := time.Now()
+
+	err := c.Exporter.Export(currentState)
+
+	c.statusMu.Lock()
+	c.status.LastExport = started
+	c.status.ExportError = err
+	if err == nil {
+		c.status.Exported = true
+	}
+	c.statusMu.Unlock()
+
+	if c.Observer != nil {
+		c.Observer.Exported(time.Since(started), err)
+	}
+
+	return err
 }
 
-// Notify tells the Controller to send a notification to its notifier
-func (c *Controller) Notify() error {
+func (c *Controller) notify(currentState []*sets.State) error {
 	if c.Notifier == nil {
 		return nil
 	}
 
-	return c.Notifier.Notify(c.CurrentState())
+	started := time.Now()
+
+	err := c.Notifier.Notify(currentState)
+
+	c.statusMu.Lock()
+	c.status.LastNotify = started
+	c.status.NotifyError = err
+	if err == nil {
+		c.status.Notified = true
+	}
+	c.statusMu.Unlock()
+
+	if c.Observer != nil {
+		c.Observer.Notified(time.Since(started), err)
+	}
+
+	return err
 }
 
-// ChangeFunc provides a change handler for managing dispatcher set changes
+// ChangeFunc provides a change handler for managing dispatcher set changes.
+// Once the Controller has been started, it records the change and returns without waiting for it to be exported, so that it never blocks the source of the change (such as a shared informer).
+// If Start has not been called, the change is exported and notified before it returns.
 func (c *Controller) ChangeFunc(state *sets.State) {
+	c.changeMu.Lock()
+
+	atomic.AddUint64(&c.revision, 1)
+
+	if c.Observer != nil {
+		c.Observer.SetChanged(c.Overrides.Apply(state.Copy(), nil))
+	}
+
+	c.changeMu.Unlock()
+
+	if atomic.LoadUint32(&c.started) == 0 {
+		if atomic.LoadUint32(&c.starting) == 0 {
+			// Without Start, there is no processing loop to export the change.
+			c.process()
+		}
+
+		// Otherwise, the initial export is made by Start, once all sets have synced.
+		return
+	}
+
+	select {
+	case c.pending <- struct{}{}:
+	default:
+		// A processing is already pending, and will take the current state.
+	}
+}
+
+// process exports the current state and notifies of it, logging any failures.
+// The state is taken once the processing of any earlier change has completed, so the latest state is always the last to be exported.
+func (c *Controller) process() {
+	c.processMu.Lock()
+	defer c.processMu.Unlock()
+
 	currentState := c.CurrentState()
 
 	log.Println("exporting...")
 
-	if c.Exporter != nil {
-		if err := c.Exporter.Export(currentState); err != nil {
-			if c.Logger != nil {
-				c.Logger.Println("failed to export current state:", err)
-			}
+	if err := c.export(currentState); err != nil {
+		if c.Logger != nil {
+			c.Logger.Println("failed to export current state:", err)
 		}
 	}
 
 	log.Println("notifying...")
 
-	if c.Notifier != nil {
-		if err := c.Notifier.Notify(currentState); err != nil {
-			if c.Logger != nil {
-				c.Logger.Println("failed to notify current state:", err)
-			}
+	if err := c.notify(currentState); err != nil {
+		if c.Logger != nil {
+			c.Logger.Println("failed to notify current state:", err)
 		}
 	}
 }
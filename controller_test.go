@@ -0,0 +1,211 @@
+package dispatchers
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/CyCoreSystems/dispatchers/v2/sets"
+	"inet.af/netaddr"
+)
+
+// blockingExporter records its exports and blocks each of them until released.
+type blockingExporter struct {
+	exports chan []*sets.State
+	release chan struct{}
+}
+
+func (e *blockingExporter) Export(states []*sets.State) error {
+	e.exports <- states
+	<-e.release
+	return nil
+}
+
+func TestControllerChangeFuncDoesNotBlock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	e := &blockingExporter{
+		exports: make(chan []*sets.State, 10),
+		release: make(chan struct{}),
+	}
+
+	set := sets.NewStaticSet(1, []*sets.Endpoint{{Address: "10.0.0.1", Port: 5060}})
+
+	c := &Controller{Exporter: e}
+	c.AddSet(set)
+
+	started := make(chan error, 1)
+	go func() {
+		started <- c.Start(ctx)
+	}()
+
+	// The initial export of Start.
+	<-e.exports
+	e.release <- struct{}{}
+
+	if err := <-started; err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+
+	// Several changes during a blocked export must neither block nor cause more than one further export.
+	c.ChangeFunc(set.State())
+	<-e.exports
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 5; i++ {
+			c.ChangeFunc(set.State())
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ChangeFunc blocked on a running export")
+	}
+
+	e.release <- struct{}{}
+
+	// The coalesced changes.
+	select {
+	case <-e.exports:
+	case <-time.After(5 * time.Second):
+		t.Fatal("pending changes were not exported")
+	}
+	e.release <- struct{}{}
+
+	select {
+	case <-e.exports:
+		t.Fatal("coalesced changes were exported more than once")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if rev := c.Revision(); rev != 6 {
+		t.Errorf("expected revision 6, got %d", rev)
+	}
+}
+
+// plainSet hides all but the methods of sets.DispatcherSet of the set it wraps, so that it does not implement sets.Lookuper.
+type plainSet struct {
+	sets.DispatcherSet
+}
+
+func TestControllerLookup(t *testing.T) {
+	c := new(Controller)
+	c.AddSet(sets.NewStaticSet(1, []*sets.Endpoint{{Address: "10.0.0.1", Port: 5060}, {Address: "10.0.1.1", Port: 5060}}))
+	c.AddSet(plainSet{sets.NewStaticSet(2, []*sets.Endpoint{{Address: "::ffff:10.0.0.1", Port: 5080}})})
+
+	if _, ok := c.Sets()[1].(sets.Lookuper); ok {
+		t.Fatal("expected set 2 to not implement sets.Lookuper")
+	}
+
+	matches := c.Lookup("10.0.0.1", 0)
+	if len(matches) != 2 {
+		t.Fatalf("expected matches in 2 sets, got %d", len(matches))
+	}
+
+	for _, state := range matches {
+		if len(state.Endpoints) != 1 {
+			t.Errorf("expected 1 matching member of set %d, got %v", state.ID, state.Endpoints)
+		}
+	}
+
+	if matches = c.Lookup("10.0.0.1", 5080); len(matches) != 1 || matches[0].ID != 2 {
+		t.Errorf("expected a match in set 2 only, got %v", matches)
+	}
+
+	if matches = c.LookupPrefix(netaddr.MustParseIPPrefix("10.0.1.0/24")); len(matches) != 1 || matches[0].ID != 1 {
+		t.Errorf("expected a prefix match in set 1 only, got %v", matches)
+	}
+}
+
+// recordingExporter records its exports.
+type recordingExporter struct {
+	exports [][]*sets.State
+}
+
+func (e *recordingExporter) Export(states []*sets.State) error {
+	e.exports = append(e.exports, states)
+	return nil
+}
+
+func TestControllerChangeFuncNotStarted(t *testing.T) {
+	e := new(recordingExporter)
+
+	set := sets.NewStaticSet(1, []*sets.Endpoint{{Address: "10.0.0.1", Port: 5060}})
+
+	c := &Controller{Exporter: e}
+	c.AddSet(set)
+
+	// Without Start, each change is exported before ChangeFunc returns.
+	c.ChangeFunc(set.State())
+
+	if len(e.exports) != 1 {
+		t.Fatalf("expected 1 export, got %d", len(e.exports))
+	}
+
+	if states := e.exports[0]; len(states) != 1 || len(states[0].Endpoints) != 1 {
+		t.Errorf("expected the export of set 1, got %v", states)
+	}
+
+	if !c.Status().Exported {
+		t.Error("expected the status to record the export")
+	}
+}
+
+// unsyncedSet is a set which reports that it has synced only once synced is set.
+type unsyncedSet struct {
+	sets.DispatcherSet
+
+	synced uint32
+}
+
+func (s *unsyncedSet) HasSynced() bool {
+	return atomic.LoadUint32(&s.synced) == 1
+}
+
+func TestControllerChangeFuncStarting(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	e := &blockingExporter{
+		exports: make(chan []*sets.State, 10),
+		release: make(chan struct{}),
+	}
+
+	set := &unsyncedSet{DispatcherSet: sets.NewStaticSet(1, []*sets.Endpoint{{Address: "10.0.0.1", Port: 5060}})}
+
+	c := &Controller{Exporter: e}
+	c.AddSet(set)
+
+	started := make(chan error, 1)
+	go func() {
+		started <- c.Start(ctx)
+	}()
+
+	// Wait for Start to be waiting for the set to sync.
+	for atomic.LoadUint32(&c.starting) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Changes while the sets sync are left to the initial export of Start.
+	c.ChangeFunc(set.State())
+
+	select {
+	case <-e.exports:
+		t.Fatal("expected no export before the sets have synced")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	atomic.StoreUint32(&set.synced, 1)
+
+	<-e.exports
+	e.release <- struct{}{}
+
+	if err := <-started; err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+}
@@ -0,0 +1,221 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"sync"
+	"time"
+
+	"github.com/CyCoreSystems/dispatchers/v2/sets"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// ConfigMapFieldManager is the field manager name under which the ConfigMapExporter makes its changes.
+const ConfigMapFieldManager = "dispatchers"
+
+// ConfigMapTimeout is the maximum amount of time to wait for the Kubernetes API to accept a ConfigMap change.
+var ConfigMapTimeout = 30 * time.Second
+
+// A Renderer formats the dispatcher sets as a document.
+type Renderer func([]*sets.State) ([]byte, error)
+
+// TemplateRenderer returns a Renderer which formats the dispatcher sets using the given template.
+// tmpl is optional and if it is set to the empty string, the DefaultFileTemplate will be used.
+func TemplateRenderer(tmpl string) (Renderer, error) {
+	if tmpl == "" {
+		tmpl = DefaultFileTemplate
+	}
+
+	t, err := template.New("exporter").Parse(tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse export template: %w", err)
+	}
+
+	return func(states []*sets.State) ([]byte, error) {
+		buf := new(bytes.Buffer)
+
+		if err := t.Execute(buf, states); err != nil {
+			return nil, err
+		}
+
+		return buf.Bytes(), nil
+	}, nil
+}
+
+// JSONRenderer returns a Renderer which formats the dispatcher sets as a JSON Snapshot.
+// source is an optional description of the producer, to be included in the snapshot.
+// The revision of the snapshot is only incremented when the dispatcher sets change, so that unchanged sets render identically.
+func JSONRenderer(source string) Renderer {
+	var revision uint64
+	var lastSets, lastOutput []byte
+	var mu sync.Mutex
+
+	return func(states []*sets.State) ([]byte, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		snapshot := NewSnapshot(revision+1, source, states)
+
+		encodedSets, err := json.Marshal(snapshot.Sets)
+		if err != nil {
+			return nil, err
+		}
+
+		if lastOutput != nil && bytes.Equal(encodedSets, lastSets) {
+			return lastOutput, nil
+		}
+
+		out, err := json.MarshalIndent(snapshot, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+
+		revision++
+		lastSets, lastOutput = encodedSets, out
+
+		return out, nil
+	}
+}
+
+// ConfigMapExporter is a dispatchers.Exporter which writes the rendered dispatcher sets to a key of a Kubernetes ConfigMap.
+// Changes are made with optimistic concurrency:  the ConfigMap is read and updated at the resourceVersion which was read, and the update is retried on conflict, so other keys and fields of the ConfigMap are preserved.
+// The ConfigMap is not written if the key already holds the rendered dispatcher sets.
+type ConfigMapExporter struct {
+	kc kubernetes.Interface
+
+	namespace string
+	name      string
+	key       string
+
+	render Renderer
+	owners []metav1.OwnerReference
+}
+
+// Export implements dispatchers.Exporter
+func (e *ConfigMapExporter) Export(sets []*sets.State) error {
+	data, err := e.render(sets)
+	if err != nil {
+		return fmt.Errorf("failed to render dispatchers: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ConfigMapTimeout)
+	defer cancel()
+
+	client := e.kc.CoreV1().ConfigMaps(e.namespace)
+
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := client.Get(ctx, e.name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			_, err = client.Create(ctx, &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            e.name,
+					Namespace:       e.namespace,
+					OwnerReferences: e.owners,
+				},
+				Data: map[string]string{
+					e.key: string(data),
+				},
+			}, metav1.CreateOptions{
+				FieldManager: ConfigMapFieldManager,
+			})
+			if apierrors.IsAlreadyExists(err) {
+				// Created by someone else in the meantime;  retry as an update.
+				return apierrors.NewConflict(v1.Resource("configmaps"), e.name, err)
+			}
+
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		owners, ownersChanged := mergeOwners(cm.OwnerReferences, e.owners)
+
+		if current, ok := cm.Data[e.key]; ok && current == string(data) && !ownersChanged {
+			return nil
+		}
+
+		cm = cm.DeepCopy()
+
+		if cm.Data == nil {
+			cm.Data = make(map[string]string)
+		}
+
+		cm.Data[e.key] = string(data)
+		cm.OwnerReferences = owners
+
+		// The update carries the resourceVersion which was read, so it fails with a conflict if the ConfigMap has since been changed.
+		_, err = client.Update(ctx, cm, metav1.UpdateOptions{
+			FieldManager: ConfigMapFieldManager,
+		})
+
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write ConfigMap %s/%s: %w", e.namespace, e.name, err)
+	}
+
+	return nil
+}
+
+// mergeOwners adds the desired owner references to the existing ones, reporting whether any were added.
+func mergeOwners(existing, desired []metav1.OwnerReference) ([]metav1.OwnerReference, bool) {
+	out := append([]metav1.OwnerReference(nil), existing...)
+
+	var changed bool
+
+	for _, want := range desired {
+		var found bool
+
+		for _, have := range existing {
+			if have.UID == want.UID {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			out = append(out, want)
+			changed = true
+		}
+	}
+
+	return out, changed
+}
+
+// NewConfigMapExporter creates a new dispatchers.Exporter which writes the dispatcher sets to the given key of a Kubernetes ConfigMap, creating the ConfigMap if necessary.
+// render is optional and if it is nil, the DefaultFileTemplate will be used, so that the key may be mounted by kamailio as its dispatcher list.
+// Any owners are set as the owner references of the ConfigMap, so that it is garbage-collected along with them.
+func NewConfigMapExporter(kc kubernetes.Interface, namespace, name, key string, render Renderer, owners ...metav1.OwnerReference) (*ConfigMapExporter, error) {
+	if kc == nil {
+		return nil, fmt.Errorf("kubernetes client is nil")
+	}
+
+	if namespace == "" || name == "" || key == "" {
+		return nil, fmt.Errorf("namespace, name, and key must all be specified")
+	}
+
+	if render == nil {
+		var err error
+
+		if render, err = TemplateRenderer(""); err != nil {
+			return nil, err
+		}
+	}
+
+	return &ConfigMapExporter{
+		kc:        kc,
+		namespace: namespace,
+		name:      name,
+		key:       key,
+		render:    render,
+		owners:    owners,
+	}, nil
+}
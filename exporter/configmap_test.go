@@ -0,0 +1,109 @@
+package exporter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/CyCoreSystems/dispatchers/v2/sets"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestConfigMapExporter(t *testing.T) {
+	kc := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "voice",
+			Name:      "kamailio",
+		},
+		Data: map[string]string{
+			"kamailio.cfg": "# managed elsewhere",
+		},
+	})
+
+	e, err := NewConfigMapExporter(kc, "voice", "kamailio", "dispatcher.json", JSONRenderer("test"))
+	if err != nil {
+		t.Fatalf("failed to create exporter: %v", err)
+	}
+
+	states := []*sets.State{
+		{ID: 1, Endpoints: []*sets.Endpoint{{Address: "10.0.0.1", Port: 5060}}},
+	}
+
+	writes := func() (n int) {
+		for _, action := range kc.Actions() {
+			if action.GetVerb() == "update" || action.GetVerb() == "create" {
+				n++
+			}
+		}
+		return n
+	}
+
+	if err = e.Export(states); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+
+	if n := writes(); n != 1 {
+		t.Fatalf("expected 1 write, got %d", n)
+	}
+
+	// An export of the same sets must not rewrite the ConfigMap.
+	if err = e.Export(states); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+
+	if n := writes(); n != 1 {
+		t.Fatalf("expected unchanged sets to not be written, got %d writes", n)
+	}
+
+	states[0].Endpoints = append(states[0].Endpoints, &sets.Endpoint{Address: "10.0.0.2", Port: 5060})
+
+	if err = e.Export(states); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+
+	if n := writes(); n != 2 {
+		t.Fatalf("expected changed sets to be written, got %d writes", n)
+	}
+
+	cm, err := kc.CoreV1().ConfigMaps("voice").Get(context.Background(), "kamailio", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get ConfigMap: %v", err)
+	}
+
+	if cm.Data["kamailio.cfg"] != "# managed elsewhere" {
+		t.Error("expected other keys of the ConfigMap to be preserved")
+	}
+
+	if cm.Data["dispatcher.json"] == "" {
+		t.Error("expected the dispatcher sets to be written")
+	}
+}
+
+func TestConfigMapExporterCreate(t *testing.T) {
+	kc := fake.NewSimpleClientset()
+
+	owner := metav1.OwnerReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "dispatchers", UID: "1234"}
+
+	e, err := NewConfigMapExporter(kc, "voice", "dispatchers", "dispatcher.list", nil, owner)
+	if err != nil {
+		t.Fatalf("failed to create exporter: %v", err)
+	}
+
+	if err = e.Export([]*sets.State{{ID: 1, Endpoints: []*sets.Endpoint{{Address: "10.0.0.1", Port: 5060}}}}); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+
+	cm, err := kc.CoreV1().ConfigMaps("voice").Get(context.Background(), "dispatchers", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get ConfigMap: %v", err)
+	}
+
+	if len(cm.OwnerReferences) != 1 || cm.OwnerReferences[0].UID != "1234" {
+		t.Errorf("expected owner reference to be set, got %v", cm.OwnerReferences)
+	}
+
+	if cm.Data["dispatcher.list"] == "" {
+		t.Error("expected the dispatcher list to be written")
+	}
+}
@@ -0,0 +1,86 @@
+package exporter
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/CyCoreSystems/dispatchers/v2/sets"
+)
+
+// DMQExporter is a dispatchers.Exporter which writes the members of the dispatcher sets as DMQ notification peers of the kamailio dmq module, suitable for inclusion in the kamailio configuration.
+// The local kamailio instance is excluded from the list, and kamailio should be configured with `multi_notify` enabled so that every peer is notified.
+// Kamailio only reads the notification peers on startup;  peers which join later are discovered through DMQ itself.
+type DMQExporter struct {
+	filename string
+	local    string
+
+	mu sync.Mutex
+}
+
+// Export implements dispatchers.Exporter
+func (e *DMQExporter) Export(sets []*sets.State) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	buf := new(bytes.Buffer)
+
+	fmt.Fprintln(buf, "# DMQ notification peers.")
+	fmt.Fprintln(buf, "# WARNING: THIS FILE IS AUTOMATICALLY GENERATED.")
+
+	for _, peer := range DMQPeers(sets, e.local) {
+		fmt.Fprintf(buf, "modparam(\"dmq\", \"notification_address\", \"%s\")\n", peer)
+	}
+
+	return writeFileAtomic(e.filename, buf.Bytes())
+}
+
+// DMQPeers returns the SIP URIs of the members of the dispatcher sets, excluding any member whose address is local.
+// IP addresses are compared in their normalized form (see sets.ParseIP), so that differently-formatted representations of the local address are still excluded.
+func DMQPeers(states []*sets.State, local string) (peers []string) {
+	seen := make(map[string]bool)
+
+	for _, s := range states {
+		for _, ep := range s.Endpoints {
+			if local != "" && sameAddress(ep.Address, local) {
+				continue
+			}
+
+			uri := "sip:" + ep.String()
+			if seen[uri] {
+				continue
+			}
+			seen[uri] = true
+
+			peers = append(peers, uri)
+		}
+	}
+
+	return peers
+}
+
+// sameAddress indicates whether two addresses are the same:  IP addresses are compared in their normalized form, and other addresses, such as hostnames, without regard to case.
+func sameAddress(a, b string) bool {
+	ipA, okA := sets.ParseIP(a)
+	ipB, okB := sets.ParseIP(b)
+
+	if okA && okB {
+		return ipA == ipB
+	}
+
+	return strings.EqualFold(a, b)
+}
+
+// NewDMQExporter creates a new dispatchers.Exporter which writes the dispatcher set members to a file as kamailio DMQ notification peers.
+// local is the address of the local kamailio instance, which will be excluded from the peers.
+func NewDMQExporter(filename string, local string) (*DMQExporter, error) {
+	if filename == "" {
+		return nil, fmt.Errorf("filename is empty")
+	}
+
+	return &DMQExporter{
+		filename: filename,
+		local:    local,
+	}, nil
+}
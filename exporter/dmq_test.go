@@ -0,0 +1,77 @@
+package exporter
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/CyCoreSystems/dispatchers/v2/sets"
+)
+
+func TestDMQPeers(t *testing.T) {
+	states := []*sets.State{
+		{
+			ID: 0,
+			Endpoints: []*sets.Endpoint{
+				{Address: "10.0.0.1", Port: 5060},
+				{Address: "::ffff:10.0.0.2", Port: 5060},
+				{Address: "fd00::1", Port: 5060},
+				{Address: "fd00::2", Port: 5060},
+				{Address: "Kamailio-0.example.com", Port: 5060},
+			},
+		},
+		{
+			ID:        1,
+			Endpoints: []*sets.Endpoint{{Address: "10.0.0.1", Port: 5060}},
+		},
+	}
+
+	tests := []struct {
+		local string
+		want  []string
+	}{
+		{"", []string{"sip:10.0.0.1:5060", "sip:[::ffff:a00:2]:5060", "sip:[fd00::1]:5060", "sip:[fd00::2]:5060", "sip:Kamailio-0.example.com:5060"}},
+		{"10.0.0.2", []string{"sip:10.0.0.1:5060", "sip:[fd00::1]:5060", "sip:[fd00::2]:5060", "sip:Kamailio-0.example.com:5060"}},
+		{"::ffff:10.0.0.1", []string{"sip:[::ffff:a00:2]:5060", "sip:[fd00::1]:5060", "sip:[fd00::2]:5060", "sip:Kamailio-0.example.com:5060"}},
+		{"fd00:0::1%eth0", []string{"sip:10.0.0.1:5060", "sip:[::ffff:a00:2]:5060", "sip:[fd00::2]:5060", "sip:Kamailio-0.example.com:5060"}},
+		{"kamailio-0.example.com", []string{"sip:10.0.0.1:5060", "sip:[::ffff:a00:2]:5060", "sip:[fd00::1]:5060", "sip:[fd00::2]:5060"}},
+	}
+
+	for _, tt := range tests {
+		if got := DMQPeers(states, tt.local); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("DMQPeers(%q) = %v, want %v", tt.local, got, tt.want)
+		}
+	}
+}
+
+func TestDMQExporter(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dmq.cfg")
+
+	e, err := NewDMQExporter(filename, "::ffff:10.0.0.1")
+	if err != nil {
+		t.Fatalf("failed to create exporter: %v", err)
+	}
+
+	if err = e.Export([]*sets.State{{ID: 0, Endpoints: []*sets.Endpoint{{Address: "10.0.0.1", Port: 5060}, {Address: "10.0.0.2", Port: 5060}}}}); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("failed to read export: %v", err)
+	}
+
+	if !strings.Contains(string(data), "modparam(\"dmq\", \"notification_address\", \"sip:10.0.0.2:5060\")\n") {
+		t.Errorf("expected the peer 10.0.0.2 in:\n%s", data)
+	}
+
+	if strings.Contains(string(data), "10.0.0.1") {
+		t.Errorf("expected the local instance to be excluded from:\n%s", data)
+	}
+
+	if _, err = NewDMQExporter("", ""); err == nil {
+		t.Error("expected an exporter without a filename to be refused")
+	}
+}
@@ -16,16 +16,27 @@ type FileExporter struct {
 }
 
 // DefaultFileTemplate is the default file exporter template, suitable for use by the kamailio dispatchers module as a flat file.
+// Endpoints which are drained are flagged as inactive, so that kamailio sends them no new traffic.
 var DefaultFileTemplate = `# Dispatcher sets.
 # WARNING: THIS FILE IS AUTOMATICALLY GENERATED.
 {{ range $set := . }}
 # Dispatcher set {{ $set.ID }}
 {{ range $index, $ep := .Endpoints -}}
-{{ $set.ID }} sip:{{ $ep }}
+{{ $set.ID }} sip:{{ $ep }}{{ if $ep.Drained }} 1{{ end }}
 {{ end -}}
 {{ end -}}
 `
 
+// RTPEngineSocketTemplate is a file exporter template which writes each dispatcher set as an rtpengine set definition of the kamailio rtpengine module, suitable for inclusion in the kamailio configuration.
+// Each endpoint is written as a `udp:` control socket, or a `udp6:` control socket if its address is an IPv6 address;  drained endpoints are omitted, along with any set whose endpoints are all drained.
+// Kamailio only reads these definitions on startup;  use the KamailioRTPEngineTable with an SQLExporter if rtpengine sets should be reloaded at runtime.
+var RTPEngineSocketTemplate = `# rtpengine sets.
+# WARNING: THIS FILE IS AUTOMATICALLY GENERATED.
+{{ range $set := . }}{{ $active := false }}{{ range .Endpoints }}{{ if not .Drained }}{{ $active = true }}{{ end }}{{ end }}{{ if $active }}
+modparam("rtpengine", "rtpengine_sock", "{{ $set.ID }} =={{ range $index, $ep := .Endpoints }}{{ if not $ep.Drained }} {{ if $ep.IsIPv6 }}udp6{{ else }}udp{{ end }}:{{ $ep }}{{ end }}{{ end }}")
+{{- end }}{{ end }}
+`
+
 // Export implements dispatchers.Exporter
 func (e *FileExporter) Export(sets []*sets.State) error {
 	f, err := os.Create(e.filename)
@@ -0,0 +1,77 @@
+package exporter
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/CyCoreSystems/dispatchers/v2/sets"
+)
+
+func exportFile(t *testing.T, tmpl string, states []*sets.State) string {
+	t.Helper()
+
+	filename := filepath.Join(t.TempDir(), "out")
+
+	e, err := NewFileExporter(filename, tmpl)
+	if err != nil {
+		t.Fatalf("failed to create exporter: %v", err)
+	}
+
+	if err = e.Export(states); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("failed to read export: %v", err)
+	}
+
+	return string(data)
+}
+
+func TestDefaultFileTemplateDrained(t *testing.T) {
+	out := exportFile(t, "", []*sets.State{
+		{
+			ID: 1,
+			Endpoints: []*sets.Endpoint{
+				{Address: "10.0.0.1", Port: 5060},
+				{Address: "10.0.0.2", Port: 5060, Drained: true},
+				{Address: "10.0.0.3", Port: 5060, NotReady: true},
+			},
+		},
+	})
+
+	for _, line := range []string{"1 sip:10.0.0.1:5060\n", "1 sip:10.0.0.2:5060 1\n", "1 sip:10.0.0.3:5060\n"} {
+		if !strings.Contains(out, line) {
+			t.Errorf("expected line %q in:\n%s", line, out)
+		}
+	}
+}
+
+func TestRTPEngineSocketTemplateDrained(t *testing.T) {
+	out := exportFile(t, RTPEngineSocketTemplate, []*sets.State{
+		{
+			ID: 1,
+			Endpoints: []*sets.Endpoint{
+				{Address: "10.0.0.1", Port: 2223},
+				{Address: "10.0.0.2", Port: 2223, Drained: true},
+			},
+		},
+		{
+			ID: 2,
+			Endpoints: []*sets.Endpoint{
+				{Address: "10.0.1.1", Port: 2223, Drained: true},
+			},
+		},
+	})
+
+	if !strings.Contains(out, `"1 == udp:10.0.0.1:2223")`) {
+		t.Errorf("expected set 1 to contain only its active socket, got:\n%s", out)
+	}
+
+	if strings.Contains(out, `"2 ==`) {
+		t.Errorf("expected set 2, whose members are all drained, to be omitted, got:\n%s", out)
+	}
+}
@@ -0,0 +1,82 @@
+package exporter
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/CyCoreSystems/dispatchers/v2/sets"
+	"inet.af/netaddr"
+)
+
+// AddressListExporter is a dispatchers.Exporter which writes the dispatcher sets as an address list for the kamailio permissions module, suitable for its `address_file` parameter.
+// Each endpoint is written as a single-host entry of the group to which its dispatcher set is mapped, and is tagged with the name of its Pod, if known.
+// Drained endpoints are still written, since the traffic of their existing calls must continue to be trusted.
+type AddressListExporter struct {
+	filename string
+
+	groups    map[int]int
+	matchPort bool
+
+	mu sync.Mutex
+}
+
+// Export implements dispatchers.Exporter
+func (e *AddressListExporter) Export(sets []*sets.State) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	buf := new(bytes.Buffer)
+
+	fmt.Fprintln(buf, "# Permissions address list.")
+	fmt.Fprintln(buf, "# WARNING: THIS FILE IS AUTOMATICALLY GENERATED.")
+	fmt.Fprintln(buf, "# group ip mask port tag")
+
+	for _, s := range sets {
+		group := mappedGroup(e.groups, s.ID)
+
+		fmt.Fprintf(buf, "\n# Dispatcher set %d\n", s.ID)
+
+		for _, ep := range s.Endpoints {
+			ip, err := netaddr.ParseIP(ep.Address)
+			if err != nil {
+				return fmt.Errorf("failed to parse address %q of dispatcher set %d: %w", ep.Address, s.ID, err)
+			}
+
+			mask := 32
+			if ip.Is6() {
+				mask = 128
+			}
+
+			var port uint32
+			if e.matchPort {
+				port = ep.Port
+			}
+
+			fmt.Fprintf(buf, "%d %s %d %d", group, ip.String(), mask, port)
+
+			if tag := ep.Attributes["pod"]; tag != "" {
+				fmt.Fprintf(buf, " %s", tag)
+			}
+
+			fmt.Fprintln(buf)
+		}
+	}
+
+	return writeFileAtomic(e.filename, buf.Bytes())
+}
+
+// NewAddressListExporter creates a new dispatchers.Exporter which writes the dispatcher sets to a kamailio permissions address list file.
+// groups is optional and maps dispatcher set IDs to permissions group IDs;  any set which is not mapped is written using its set ID as its group ID.
+// If matchPort is set, each entry is restricted to the port of its endpoint;  otherwise, any source port is trusted.
+func NewAddressListExporter(filename string, groups map[int]int, matchPort bool) (*AddressListExporter, error) {
+	if filename == "" {
+		return nil, fmt.Errorf("filename is empty")
+	}
+
+	return &AddressListExporter{
+		filename:  filename,
+		groups:    groups,
+		matchPort: matchPort,
+	}, nil
+}
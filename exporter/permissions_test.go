@@ -0,0 +1,76 @@
+package exporter
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/CyCoreSystems/dispatchers/v2/sets"
+)
+
+func TestAddressListExporter(t *testing.T) {
+	states := []*sets.State{
+		{
+			ID: 1,
+			Endpoints: []*sets.Endpoint{
+				{Address: "10.0.0.1", Port: 5060, Attributes: map[string]string{"pod": "asterisk-0"}},
+				{Address: "10.0.0.2", Port: 5080, Drained: true},
+				{Address: "fd00:0::1", Port: 5060},
+			},
+		},
+		{
+			ID:        2,
+			Endpoints: []*sets.Endpoint{{Address: "10.0.1.1", Port: 5060}},
+		},
+	}
+
+	header := "# Permissions address list.\n# WARNING: THIS FILE IS AUTOMATICALLY GENERATED.\n# group ip mask port tag\n"
+
+	tests := []struct {
+		matchPort bool
+		want      string
+	}{
+		{false, header + "\n# Dispatcher set 1\n10 10.0.0.1 32 0 asterisk-0\n10 10.0.0.2 32 0\n10 fd00::1 128 0\n\n# Dispatcher set 2\n2 10.0.1.1 32 0\n"},
+		{true, header + "\n# Dispatcher set 1\n10 10.0.0.1 32 5060 asterisk-0\n10 10.0.0.2 32 5080\n10 fd00::1 128 5060\n\n# Dispatcher set 2\n2 10.0.1.1 32 5060\n"},
+	}
+
+	for _, tt := range tests {
+		filename := filepath.Join(t.TempDir(), "address.list")
+
+		// Set 1 is mapped to group 10, while set 2 keeps its own ID.  The drained member is still trusted.
+		e, err := NewAddressListExporter(filename, map[int]int{1: 10}, tt.matchPort)
+		if err != nil {
+			t.Fatalf("failed to create exporter: %v", err)
+		}
+
+		if err = e.Export(states); err != nil {
+			t.Fatalf("export failed: %v", err)
+		}
+
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("failed to read export: %v", err)
+		}
+
+		if string(data) != tt.want {
+			t.Errorf("matchPort %v: expected:\n%s\ngot:\n%s", tt.matchPort, tt.want, data)
+		}
+	}
+}
+
+func TestAddressListExporterInvalidAddress(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "address.list")
+
+	e, err := NewAddressListExporter(filename, nil, false)
+	if err != nil {
+		t.Fatalf("failed to create exporter: %v", err)
+	}
+
+	if err = e.Export([]*sets.State{{ID: 1, Endpoints: []*sets.Endpoint{{Address: "asterisk.example.com", Port: 5060}}}}); err == nil {
+		t.Error("expected a member which is not an IP address to be refused")
+	}
+
+	if _, err = NewAddressListExporter("", nil, false); err == nil {
+		t.Error("expected an exporter without a filename to be refused")
+	}
+}
@@ -0,0 +1,93 @@
+package exporter
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/CyCoreSystems/dispatchers/v2/sets"
+)
+
+// PJSIPExporter is a dispatchers.Exporter which writes the dispatcher sets as Asterisk PJSIP configuration, suitable for inclusion in `pjsip.conf`.
+// Each dispatcher set is written as an `identify` section, matching the addresses of its members to a PJSIP endpoint, and optionally an `aor` section, listing its members as static contacts.
+// Drained members are still matched by the `identify` section, so that the traffic of their existing calls is still identified, but are omitted from the `aor` section, so that they are sent no new traffic.
+// The endpoints themselves must be defined elsewhere in the PJSIP configuration.
+type PJSIPExporter struct {
+	filename string
+
+	endpoints map[int]string
+	aors      bool
+
+	mu sync.Mutex
+}
+
+// Export implements dispatchers.Exporter
+func (e *PJSIPExporter) Export(sets []*sets.State) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	buf := new(bytes.Buffer)
+
+	fmt.Fprintln(buf, "; PJSIP dispatcher sets.")
+	fmt.Fprintln(buf, "; WARNING: THIS FILE IS AUTOMATICALLY GENERATED.")
+
+	for _, s := range sets {
+		endpoint, ok := e.endpoints[s.ID]
+		if !ok {
+			endpoint = fmt.Sprintf("dispatcher-%d", s.ID)
+		}
+
+		fmt.Fprintf(buf, "\n; Dispatcher set %d\n", s.ID)
+
+		// An identify section without any match is invalid, so it is omitted until the set has members.
+		if len(s.Endpoints) > 0 {
+			fmt.Fprintf(buf, "[%s-identify]\n", endpoint)
+			fmt.Fprintln(buf, "type=identify")
+			fmt.Fprintf(buf, "endpoint=%s\n", endpoint)
+
+			seen := make(map[string]bool)
+			for _, ep := range s.Endpoints {
+				if seen[ep.Address] {
+					continue
+				}
+				seen[ep.Address] = true
+
+				fmt.Fprintf(buf, "match=%s\n", ep.Address)
+			}
+		}
+
+		if e.aors {
+			if len(s.Endpoints) > 0 {
+				fmt.Fprintln(buf)
+			}
+
+			fmt.Fprintf(buf, "[%s]\n", endpoint)
+			fmt.Fprintln(buf, "type=aor")
+
+			for _, ep := range s.Endpoints {
+				if ep.Drained {
+					continue
+				}
+
+				fmt.Fprintf(buf, "contact=sip:%s\n", ep.String())
+			}
+		}
+	}
+
+	return writeFileAtomic(e.filename, buf.Bytes())
+}
+
+// NewPJSIPExporter creates a new dispatchers.Exporter which writes the dispatcher sets to a file as Asterisk PJSIP configuration.
+// endpoints is optional and maps dispatcher set IDs to the names of PJSIP endpoints;  any set which is not mapped is written for an endpoint named `dispatcher-<id>`.
+// If aors is set, an `aor` section named for the endpoint is also written for each set, with a static contact for each member.
+func NewPJSIPExporter(filename string, endpoints map[int]string, aors bool) (*PJSIPExporter, error) {
+	if filename == "" {
+		return nil, fmt.Errorf("filename is empty")
+	}
+
+	return &PJSIPExporter{
+		filename:  filename,
+		endpoints: endpoints,
+		aors:      aors,
+	}, nil
+}
@@ -0,0 +1,178 @@
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/CyCoreSystems/dispatchers/v2/sets"
+	"sigs.k8s.io/yaml"
+)
+
+// Snapshot describes the complete state of the dispatcher sets at the time of an export.
+type Snapshot struct {
+	// Revision is the revision of the dispatcher sets, such as that of the Controller.
+	Revision uint64 `json:"revision"`
+
+	// Timestamp is the time at which the snapshot was taken.
+	Timestamp time.Time `json:"timestamp"`
+
+	// Source is a description of the producer of the snapshot.
+	Source string `json:"source,omitempty"`
+
+	// Sets is the list of dispatcher sets.
+	Sets []*SnapshotSet `json:"sets"`
+}
+
+// SnapshotSet describes a single dispatcher set within a Snapshot.
+type SnapshotSet struct {
+	ID        int                 `json:"id"`
+	Endpoints []*SnapshotEndpoint `json:"endpoints"`
+}
+
+// SnapshotEndpoint describes a single dispatcher set member within a Snapshot.
+// Ready is false for members which are known to not be ready, and Drained is true for members which have been drained by a manual override;  neither should be sent new traffic.
+type SnapshotEndpoint struct {
+	Address    string            `json:"address"`
+	Port       uint32            `json:"port"`
+	Ready      bool              `json:"ready"`
+	Drained    bool              `json:"drained,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// NewSnapshotEndpoint describes the given dispatcher set member.
+func NewSnapshotEndpoint(ep *sets.Endpoint) *SnapshotEndpoint {
+	return &SnapshotEndpoint{
+		Address:    ep.Address,
+		Port:       ep.Port,
+		Ready:      !ep.NotReady,
+		Drained:    ep.Drained,
+		Attributes: ep.Attributes,
+	}
+}
+
+// NewSnapshot builds a Snapshot of the given dispatcher sets.
+func NewSnapshot(revision uint64, source string, states []*sets.State) *Snapshot {
+	s := &Snapshot{
+		Revision:  revision,
+		Timestamp: time.Now().UTC(),
+		Source:    source,
+		Sets:      []*SnapshotSet{},
+	}
+
+	for _, state := range states {
+		set := &SnapshotSet{
+			ID:        state.ID,
+			Endpoints: []*SnapshotEndpoint{},
+		}
+
+		for _, ep := range state.Endpoints {
+			set.Endpoints = append(set.Endpoints, NewSnapshotEndpoint(ep))
+		}
+
+		s.Sets = append(s.Sets, set)
+	}
+
+	return s
+}
+
+// SnapshotExporter is a dispatchers.Exporter which atomically writes a Snapshot of the dispatcher sets to a file, encoded as JSON or YAML.
+type SnapshotExporter struct {
+	// Revision returns the revision of the dispatcher sets, such as Controller.Revision.  If not specified, the exporter numbers its own exports, starting again from 1 whenever it is created.
+	Revision func() uint64
+
+	filename string
+	source   string
+	encode   func(interface{}) ([]byte, error)
+
+	revision uint64
+
+	mu sync.Mutex
+}
+
+// Export implements dispatchers.Exporter
+func (e *SnapshotExporter) Export(sets []*sets.State) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	revision := e.revision + 1
+	if e.Revision != nil {
+		revision = e.Revision()
+	}
+
+	data, err := e.encode(NewSnapshot(revision, e.source, sets))
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+
+	if err = writeFileAtomic(e.filename, data); err != nil {
+		return err
+	}
+
+	e.revision++
+
+	return nil
+}
+
+// NewJSONExporter creates a new dispatchers.Exporter which writes a JSON Snapshot of the dispatcher sets to a file.
+// source is an optional description of the producer, to be included in the snapshot.
+func NewJSONExporter(filename string, source string) (*SnapshotExporter, error) {
+	return newSnapshotExporter(filename, source, func(v interface{}) ([]byte, error) {
+		return json.MarshalIndent(v, "", "  ")
+	})
+}
+
+// NewYAMLExporter creates a new dispatchers.Exporter which writes a YAML Snapshot of the dispatcher sets to a file.
+// source is an optional description of the producer, to be included in the snapshot.
+func NewYAMLExporter(filename string, source string) (*SnapshotExporter, error) {
+	return newSnapshotExporter(filename, source, yaml.Marshal)
+}
+
+func newSnapshotExporter(filename string, source string, encode func(interface{}) ([]byte, error)) (*SnapshotExporter, error) {
+	if filename == "" {
+		return nil, fmt.Errorf("filename is empty")
+	}
+
+	return &SnapshotExporter{
+		filename: filename,
+		source:   source,
+		encode:   encode,
+	}, nil
+}
+
+// writeFileAtomic replaces the contents of the named file with data, such that readers never observe a partially-written file.
+func writeFileAtomic(filename string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for %s: %w", filename, err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %w", f.Name(), err)
+	}
+
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync %s: %w", f.Name(), err)
+	}
+
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", f.Name(), err)
+	}
+
+	if err = os.Chmod(f.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to set permissions of %s: %w", f.Name(), err)
+	}
+
+	if err = os.Rename(f.Name(), filename); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", filename, err)
+	}
+
+	return nil
+}
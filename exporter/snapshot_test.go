@@ -0,0 +1,168 @@
+package exporter
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/CyCoreSystems/dispatchers/v2/sets"
+	"sigs.k8s.io/yaml"
+)
+
+func TestNewSnapshotDrained(t *testing.T) {
+	snapshot := NewSnapshot(1, "", []*sets.State{
+		{
+			ID: 1,
+			Endpoints: []*sets.Endpoint{
+				{Address: "10.0.0.1", Port: 5060},
+				{Address: "10.0.0.2", Port: 5060, Drained: true},
+				{Address: "10.0.0.3", Port: 5060, NotReady: true},
+			},
+		},
+	})
+
+	eps := snapshot.Sets[0].Endpoints
+	if len(eps) != 3 {
+		t.Fatalf("expected 3 members, got %d", len(eps))
+	}
+
+	if !eps[0].Ready || eps[0].Drained {
+		t.Errorf("expected 10.0.0.1 to be ready and not drained, got %+v", eps[0])
+	}
+
+	if !eps[1].Ready || !eps[1].Drained {
+		t.Errorf("expected 10.0.0.2 to be ready and drained, got %+v", eps[1])
+	}
+
+	if eps[2].Ready || eps[2].Drained {
+		t.Errorf("expected 10.0.0.3 to be not ready and not drained, got %+v", eps[2])
+	}
+}
+
+func TestSnapshotExporterYAML(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dispatchers.yaml")
+
+	e, err := NewYAMLExporter(filename, "test")
+	if err != nil {
+		t.Fatalf("failed to create exporter: %v", err)
+	}
+
+	var revision uint64 = 41
+	e.Revision = func() uint64 { return revision }
+
+	states := []*sets.State{{ID: 1, Endpoints: []*sets.Endpoint{{Address: "10.0.0.1", Port: 5060, Attributes: map[string]string{"pod": "asterisk-0"}}}}}
+
+	for i := 0; i < 2; i++ {
+		revision++
+
+		if err = e.Export(states); err != nil {
+			t.Fatalf("export failed: %v", err)
+		}
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("failed to read export: %v", err)
+	}
+
+	for _, want := range []string{"revision: 43\n", "source: test\n", "- address: 10.0.0.1\n", "pod: asterisk-0\n"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("expected %q in:\n%s", want, data)
+		}
+	}
+
+	snapshot := new(Snapshot)
+	if err = yaml.Unmarshal(data, snapshot); err != nil {
+		t.Fatalf("failed to decode snapshot: %v", err)
+	}
+
+	if snapshot.Revision != 43 || len(snapshot.Sets) != 1 || snapshot.Sets[0].Endpoints[0].Port != 5060 || snapshot.Timestamp.IsZero() {
+		t.Errorf("unexpected snapshot: %+v", snapshot)
+	}
+}
+
+func TestSnapshotExporterRevision(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dispatchers.json")
+
+	e, err := NewJSONExporter(filename, "")
+	if err != nil {
+		t.Fatalf("failed to create exporter: %v", err)
+	}
+
+	// Without a Revision function, the exports are numbered.
+	for want := uint64(1); want <= 2; want++ {
+		if err = e.Export(nil); err != nil {
+			t.Fatalf("export failed: %v", err)
+		}
+
+		snapshot := new(Snapshot)
+
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("failed to read export: %v", err)
+		}
+
+		if err = json.Unmarshal(data, snapshot); err != nil {
+			t.Fatalf("failed to decode snapshot: %v", err)
+		}
+
+		if snapshot.Revision != want || snapshot.Sets == nil {
+			t.Errorf("expected revision %d with an empty list of sets, got %+v", want, snapshot)
+		}
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "out")
+
+	if err := ioutil.WriteFile(filename, []byte("old"), 0600); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	// Readers which hold the old file keep reading it, while new readers see the new file.
+	old, err := os.Open(filename)
+	if err != nil {
+		t.Fatalf("failed to open file: %v", err)
+	}
+	defer old.Close()
+
+	if err = writeFileAtomic(filename, []byte("new")); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	if data, _ := ioutil.ReadAll(old); string(data) != "old" { // nolint: errcheck
+		t.Errorf("expected the open file to still hold the old contents, got %q", data)
+	}
+
+	if data, _ := ioutil.ReadFile(filename); string(data) != "new" { // nolint: errcheck
+		t.Errorf("expected the new contents, got %q", data)
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatalf("failed to stat file: %v", err)
+	}
+
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("expected mode 0644, got %s", info.Mode().Perm())
+	}
+
+	// No temporary files are left behind.
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read directory: %v", err)
+	}
+
+	if len(entries) != 1 {
+		t.Errorf("expected only the written file, got %d entries", len(entries))
+	}
+
+	// Writes into a missing directory fail.
+	if err = writeFileAtomic(filepath.Join(dir, "missing", "out"), []byte("new")); err == nil {
+		t.Error("expected the write into a missing directory to fail")
+	}
+}
@@ -0,0 +1,486 @@
+package exporter
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/CyCoreSystems/dispatchers/v2/sets"
+)
+
+// SQLDialect describes the syntax differences between database engines which matter to the SQLExporter.
+type SQLDialect interface {
+	// Placeholder returns the bind parameter for the n'th (1-based) argument of a statement.
+	Placeholder(n int) string
+
+	// Quote returns the quoted form of the given identifier.
+	Quote(ident string) string
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Placeholder(n int) string { return "?" }
+
+func (mysqlDialect) Quote(ident string) string {
+	return "`" + strings.ReplaceAll(ident, "`", "``") + "`"
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) Placeholder(n int) string { return fmt.Sprintf("$%d", n) }
+
+func (postgresDialect) Quote(ident string) string {
+	return `"` + strings.ReplaceAll(ident, `"`, `""`) + `"`
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Placeholder(n int) string { return "?" }
+
+func (sqliteDialect) Quote(ident string) string {
+	return `"` + strings.ReplaceAll(ident, `"`, `""`) + `"`
+}
+
+var (
+	// MySQL is the SQLDialect for MySQL and MariaDB.
+	MySQL SQLDialect = mysqlDialect{}
+
+	// PostgreSQL is the SQLDialect for PostgreSQL.
+	PostgreSQL SQLDialect = postgresDialect{}
+
+	// SQLite is the SQLDialect for SQLite.
+	SQLite SQLDialect = sqliteDialect{}
+)
+
+// SQLRow is a set of column values, ordered as the Columns of its SQLTable.
+type SQLRow []interface{}
+
+// SQLTable describes how dispatcher sets are mapped onto the rows of a database table.
+type SQLTable struct {
+	// Name is the name of the table.
+	Name string
+
+	// Columns is the list of columns managed by the exporter.  The first
+	// column identifies the group (such as the dispatcher set ID) to which a
+	// row belongs, and the first KeyColumns columns together uniquely
+	// identify a row.  Any other columns of the table are left to their
+	// database defaults.
+	Columns []string
+
+	// KeyColumns is the number of leading Columns which uniquely identify a row.
+	KeyColumns int
+
+	// Rows renders the dispatcher sets into the list of groups the exporter
+	// owns and the desired rows for those groups.  Rows of other groups are
+	// never modified.
+	Rows func([]*sets.State) (groups []interface{}, rows []SQLRow)
+}
+
+// KamailioDispatcherTable describes the `dispatcher` table of the kamailio dispatcher module.
+// Each dispatcher set is owned by the exporter, and its endpoint attributes are written to the `attrs` column.
+// Endpoints which are drained are flagged as inactive.
+var KamailioDispatcherTable = &SQLTable{
+	Name:       "dispatcher",
+	Columns:    []string{"setid", "destination", "flags", "priority", "attrs", "description"},
+	KeyColumns: 2,
+	Rows: func(states []*sets.State) (groups []interface{}, rows []SQLRow) {
+		for _, s := range states {
+			groups = append(groups, s.ID)
+
+			for _, ep := range s.Endpoints {
+				rows = append(rows, SQLRow{s.ID, "sip:" + ep.String(), inactiveState(ep), 0, sets.FormatAttributes(ep.Attributes), ""})
+			}
+		}
+
+		return groups, rows
+	},
+}
+
+// OpenSIPSDispatcherTable describes the `dispatcher` table of the OpenSIPS dispatcher module.
+// Endpoints are written with a weight of 1, as inactive if they are drained and as active otherwise, and their attributes are written to the `attrs` column.
+var OpenSIPSDispatcherTable = &SQLTable{
+	Name:       "dispatcher",
+	Columns:    []string{"setid", "destination", "state", "weight", "priority", "attrs", "description"},
+	KeyColumns: 2,
+	Rows: func(states []*sets.State) (groups []interface{}, rows []SQLRow) {
+		for _, s := range states {
+			groups = append(groups, s.ID)
+
+			for _, ep := range s.Endpoints {
+				rows = append(rows, SQLRow{s.ID, "sip:" + ep.String(), inactiveState(ep), "1", 0, sets.FormatAttributes(ep.Attributes), ""})
+			}
+		}
+
+		return groups, rows
+	},
+}
+
+// OpenSIPSLoadBalancerTable returns a description of the `load_balancer` table of the OpenSIPS load_balancer module.
+// Each dispatcher set is written as the load balancing group of the same ID, and each endpoint which is not drained is given the provided resources definition, such as "pstn=32;transc=16".
+func OpenSIPSLoadBalancerTable(resources string) *SQLTable {
+	return &SQLTable{
+		Name:       "load_balancer",
+		Columns:    []string{"group_id", "dst_uri", "resources", "attrs", "description"},
+		KeyColumns: 2,
+		Rows: func(states []*sets.State) (groups []interface{}, rows []SQLRow) {
+			for _, s := range states {
+				groups = append(groups, s.ID)
+
+				for _, ep := range s.Endpoints {
+					if ep.Drained {
+						continue
+					}
+
+					rows = append(rows, SQLRow{s.ID, "sip:" + ep.String(), resources, sets.FormatAttributes(ep.Attributes), ""})
+				}
+			}
+
+			return groups, rows
+		},
+	}
+}
+
+// KamailioRTPEngineTable describes the `rtpengine` table of the kamailio rtpengine module.
+// Each dispatcher set is written as the rtpengine set of the same ID, and each endpoint is written as a control socket of weight 1:  `udp:`, or `udp6:` if its address is an IPv6 address.  Drained endpoints are written as disabled.
+var KamailioRTPEngineTable = &SQLTable{
+	Name:       "rtpengine",
+	Columns:    []string{"setid", "url", "weight", "disabled"},
+	KeyColumns: 2,
+	Rows: func(states []*sets.State) (groups []interface{}, rows []SQLRow) {
+		for _, s := range states {
+			groups = append(groups, s.ID)
+
+			for _, ep := range s.Endpoints {
+				rows = append(rows, SQLRow{s.ID, rtpengineSocket(ep), 1, inactiveState(ep)})
+			}
+		}
+
+		return groups, rows
+	},
+}
+
+// KamailioLCRGatewayTable returns a description of the `lcr_gw` table of the kamailio lcr module.
+// lcrIDs is optional and maps dispatcher set IDs to LCR instance IDs;  any set which is not mapped is written using its set ID as its LCR instance ID.
+// Each endpoint which is not drained is written as a SIP gateway named by its address and port, and tagged with the name of its Pod, if known.
+func KamailioLCRGatewayTable(lcrIDs map[int]int) *SQLTable {
+	return &SQLTable{
+		Name:       "lcr_gw",
+		Columns:    []string{"lcr_id", "gw_name", "ip_addr", "port", "uri_scheme", "strip", "tag", "flags"},
+		KeyColumns: 2,
+		Rows: func(states []*sets.State) (groups []interface{}, rows []SQLRow) {
+			for _, s := range states {
+				id := mappedGroup(lcrIDs, s.ID)
+				groups = append(groups, id)
+
+				for _, ep := range s.Endpoints {
+					if ep.Drained {
+						continue
+					}
+
+					rows = append(rows, SQLRow{id, ep.String(), ep.Address, ep.Port, 1, 0, ep.Attributes["pod"], 0})
+				}
+			}
+
+			return groups, rows
+		},
+	}
+}
+
+// KamailioDRoutingGatewayTable returns a description of the `dr_gateways` table of the kamailio drouting module.
+// types is optional and maps dispatcher set IDs to gateway types;  any set which is not mapped is written using its set ID as its gateway type.
+// Each endpoint which is not drained is written as a gateway of its set's type, described by the name of its Pod, if known.
+func KamailioDRoutingGatewayTable(types map[int]int) *SQLTable {
+	return &SQLTable{
+		Name:       "dr_gateways",
+		Columns:    []string{"type", "address", "strip", "pri_prefix", "attrs", "description"},
+		KeyColumns: 2,
+		Rows: func(states []*sets.State) (groups []interface{}, rows []SQLRow) {
+			for _, s := range states {
+				t := mappedGroup(types, s.ID)
+				groups = append(groups, t)
+
+				for _, ep := range s.Endpoints {
+					if ep.Drained {
+						continue
+					}
+
+					rows = append(rows, SQLRow{t, ep.String(), 0, "", sets.FormatAttributes(ep.Attributes), ep.Attributes["pod"]})
+				}
+			}
+
+			return groups, rows
+		},
+	}
+}
+
+// rtpengineSocket returns the rtpengine control socket URL of an endpoint.
+func rtpengineSocket(ep *sets.Endpoint) string {
+	if ep.IsIPv6() {
+		return "udp6:" + ep.String()
+	}
+
+	return "udp:" + ep.String()
+}
+
+// mappedGroup returns the group to which the given dispatcher set is mapped, or the set ID itself if it is not mapped.
+func mappedGroup(groups map[int]int, id int) int {
+	if group, ok := groups[id]; ok {
+		return group
+	}
+
+	return id
+}
+
+// SQLExporter is a dispatchers.Exporter which reconciles dispatcher set data into a database table.
+// Each export is performed in a single transaction, and only rows which have changed are inserted, updated, or deleted.
+type SQLExporter struct {
+	db      *sql.DB
+	dialect SQLDialect
+	table   *SQLTable
+}
+
+// Export implements dispatchers.Exporter
+func (e *SQLExporter) Export(sets []*sets.State) error {
+	groups, rows := e.table.Rows(sets)
+	if len(groups) == 0 {
+		return nil
+	}
+
+	tx, err := e.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // nolint: errcheck
+
+	current, err := e.load(tx, groups)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+
+	for _, row := range rows {
+		if len(row) != len(e.table.Columns) {
+			return fmt.Errorf("row has %d values for %d columns of table %s", len(row), len(e.table.Columns), e.table.Name)
+		}
+
+		key := rowKey(row[:e.table.KeyColumns])
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		existing, ok := current[key]
+		if !ok {
+			if err = e.insert(tx, row); err != nil {
+				return err
+			}
+			continue
+		}
+
+		delete(current, key)
+
+		if rowKey(existing) != rowKey(row) {
+			if err = e.update(tx, row); err != nil {
+				return err
+			}
+		}
+	}
+
+	stale := make([]string, 0, len(current))
+	for key := range current {
+		stale = append(stale, key)
+	}
+	sort.Strings(stale)
+
+	for _, key := range stale {
+		if err = e.delete(tx, current[key][:e.table.KeyColumns]); err != nil {
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// load returns the current rows of the given groups, keyed by their key columns.
+func (e *SQLExporter) load(tx *sql.Tx, groups []interface{}) (map[string]SQLRow, error) {
+	var columns, placeholders []string
+	for _, c := range e.table.Columns {
+		columns = append(columns, e.dialect.Quote(c))
+	}
+	for i := range groups {
+		placeholders = append(placeholders, e.dialect.Placeholder(i+1))
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s IN (%s)",
+		strings.Join(columns, ", "),
+		e.dialect.Quote(e.table.Name),
+		columns[0],
+		strings.Join(placeholders, ", "),
+	)
+
+	rows, err := tx.Query(query, groups...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query table %s: %w", e.table.Name, err)
+	}
+	defer rows.Close()
+
+	out := make(map[string]SQLRow)
+
+	for rows.Next() {
+		row := make(SQLRow, len(e.table.Columns))
+
+		dest := make([]interface{}, len(row))
+		for i := range row {
+			dest[i] = &row[i]
+		}
+
+		if err = rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("failed to read row of table %s: %w", e.table.Name, err)
+		}
+
+		out[rowKey(row[:e.table.KeyColumns])] = row
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read table %s: %w", e.table.Name, err)
+	}
+
+	return out, nil
+}
+
+func (e *SQLExporter) insert(tx *sql.Tx, row SQLRow) error {
+	var columns, placeholders []string
+	for i, c := range e.table.Columns {
+		columns = append(columns, e.dialect.Quote(c))
+		placeholders = append(placeholders, e.dialect.Placeholder(i+1))
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		e.dialect.Quote(e.table.Name),
+		strings.Join(columns, ", "),
+		strings.Join(placeholders, ", "),
+	)
+
+	if _, err := tx.Exec(query, row...); err != nil {
+		return fmt.Errorf("failed to insert into table %s: %w", e.table.Name, err)
+	}
+
+	return nil
+}
+
+func (e *SQLExporter) update(tx *sql.Tx, row SQLRow) error {
+	keys := e.table.KeyColumns
+	if keys == len(e.table.Columns) {
+		return nil
+	}
+
+	// Bind the assigned values first and the key values last, matching their
+	// order of appearance in the statement.
+	args := append(append(SQLRow{}, row[keys:]...), row[:keys]...)
+
+	var assignments, conditions []string
+	for i, c := range append(append([]string{}, e.table.Columns[keys:]...), e.table.Columns[:keys]...) {
+		clause := fmt.Sprintf("%s = %s", e.dialect.Quote(c), e.dialect.Placeholder(i+1))
+
+		if i < len(e.table.Columns)-keys {
+			assignments = append(assignments, clause)
+		} else {
+			conditions = append(conditions, clause)
+		}
+	}
+
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE %s",
+		e.dialect.Quote(e.table.Name),
+		strings.Join(assignments, ", "),
+		strings.Join(conditions, " AND "),
+	)
+
+	if _, err := tx.Exec(query, args...); err != nil {
+		return fmt.Errorf("failed to update table %s: %w", e.table.Name, err)
+	}
+
+	return nil
+}
+
+func (e *SQLExporter) delete(tx *sql.Tx, key SQLRow) error {
+	var conditions []string
+	for i, c := range e.table.Columns[:e.table.KeyColumns] {
+		conditions = append(conditions, fmt.Sprintf("%s = %s", e.dialect.Quote(c), e.dialect.Placeholder(i+1)))
+	}
+
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s",
+		e.dialect.Quote(e.table.Name),
+		strings.Join(conditions, " AND "),
+	)
+
+	if _, err := tx.Exec(query, key...); err != nil {
+		return fmt.Errorf("failed to delete from table %s: %w", e.table.Name, err)
+	}
+
+	return nil
+}
+
+// rowKey returns a comparable representation of a set of column values.
+// Values are compared by their textual form, since drivers differ in the types they return.
+func rowKey(values SQLRow) string {
+	var b strings.Builder
+
+	for _, v := range values {
+		switch t := v.(type) {
+		case nil:
+		case []byte:
+			b.Write(t)
+		default:
+			fmt.Fprint(&b, t)
+		}
+		b.WriteByte(0)
+	}
+
+	return b.String()
+}
+
+// NewSQLExporter creates a new dispatchers.Exporter which reconciles the dispatcher sets into a database table.
+// table is optional and if it is nil, the KamailioDispatcherTable will be used.
+func NewSQLExporter(db *sql.DB, dialect SQLDialect, table *SQLTable) (*SQLExporter, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database is nil")
+	}
+
+	if dialect == nil {
+		return nil, fmt.Errorf("dialect is nil")
+	}
+
+	if table == nil {
+		table = KamailioDispatcherTable
+	}
+
+	if table.Name == "" || table.Rows == nil {
+		return nil, fmt.Errorf("table is incomplete")
+	}
+
+	if table.KeyColumns < 1 || table.KeyColumns > len(table.Columns) {
+		return nil, fmt.Errorf("table %s has an invalid number of key columns", table.Name)
+	}
+
+	return &SQLExporter{
+		db:      db,
+		dialect: dialect,
+		table:   table,
+	}, nil
+}
+
+// inactiveState returns the state of an endpoint, for the kamailio dispatcher flags, the OpenSIPS dispatcher state, and the rtpengine disabled flag:  1 (inactive) if it is drained, and 0 (active) otherwise.
+// The readiness of an endpoint does not affect its state.
+func inactiveState(ep *sets.Endpoint) int {
+	if ep.Drained {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,381 @@
+package exporter
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/CyCoreSystems/dispatchers/v2/sets"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const kamailioDispatcherSchema = `CREATE TABLE dispatcher (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	setid INTEGER NOT NULL DEFAULT 0,
+	destination VARCHAR(192) NOT NULL DEFAULT '',
+	flags INTEGER NOT NULL DEFAULT 0,
+	priority INTEGER NOT NULL DEFAULT 0,
+	attrs VARCHAR(128) NOT NULL DEFAULT '',
+	description VARCHAR(64) NOT NULL DEFAULT ''
+)`
+
+type dispatcherRow struct {
+	id          int64
+	setid       int
+	destination string
+	attrs       string
+}
+
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	// Each connection to an in-memory database has its own database.
+	db.SetMaxOpenConns(1)
+
+	if _, err = db.Exec(kamailioDispatcherSchema); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+
+	return db
+}
+
+func readDispatcherRows(t *testing.T, db *sql.DB) map[string]*dispatcherRow {
+	t.Helper()
+
+	rows, err := db.Query("SELECT id, setid, destination, attrs FROM dispatcher")
+	if err != nil {
+		t.Fatalf("failed to query table: %v", err)
+	}
+	defer rows.Close()
+
+	out := make(map[string]*dispatcherRow)
+
+	for rows.Next() {
+		row := new(dispatcherRow)
+
+		if err = rows.Scan(&row.id, &row.setid, &row.destination, &row.attrs); err != nil {
+			t.Fatalf("failed to read row: %v", err)
+		}
+
+		out[row.destination] = row
+	}
+
+	if err = rows.Err(); err != nil {
+		t.Fatalf("failed to read table: %v", err)
+	}
+
+	return out
+}
+
+func TestSQLExporter(t *testing.T) {
+	db := newTestDB(t)
+
+	// A row of a set which is not managed by the exporter must be left alone.
+	if _, err := db.Exec(`INSERT INTO dispatcher (setid, destination) VALUES (9, 'sip:192.0.2.1:5060')`); err != nil {
+		t.Fatalf("failed to insert unmanaged row: %v", err)
+	}
+
+	e, err := NewSQLExporter(db, SQLite, nil)
+	if err != nil {
+		t.Fatalf("failed to create exporter: %v", err)
+	}
+
+	err = e.Export([]*sets.State{
+		{
+			ID: 1,
+			Endpoints: []*sets.Endpoint{
+				{Address: "10.0.0.1", Port: 5060, Attributes: map[string]string{"pod": "kamailio-0", "node": "a"}},
+				{Address: "10.0.0.2", Port: 5060},
+			},
+		},
+		{
+			ID: 2,
+			Endpoints: []*sets.Endpoint{
+				{Address: "10.0.1.1", Port: 5080},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("initial export failed: %v", err)
+	}
+
+	initial := readDispatcherRows(t, db)
+
+	if len(initial) != 4 {
+		t.Fatalf("expected 4 rows, got %d", len(initial))
+	}
+
+	if row := initial["sip:10.0.0.1:5060"]; row == nil || row.setid != 1 || row.attrs != "node=a;pod=kamailio-0" {
+		t.Errorf("unexpected row for 10.0.0.1: %+v", row)
+	}
+
+	if row := initial["sip:10.0.1.1:5080"]; row == nil || row.setid != 2 {
+		t.Errorf("unexpected row for 10.0.1.1: %+v", row)
+	}
+
+	// Change the attributes of one member of set 1, remove another, and add a new one, while removing set 2 from the export entirely.
+	err = e.Export([]*sets.State{
+		{
+			ID: 1,
+			Endpoints: []*sets.Endpoint{
+				{Address: "10.0.0.1", Port: 5060, Attributes: map[string]string{"pod": "kamailio-0", "node": "b"}},
+				{Address: "10.0.0.3", Port: 5060},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("second export failed: %v", err)
+	}
+
+	current := readDispatcherRows(t, db)
+
+	if len(current) != 4 {
+		t.Fatalf("expected 4 rows, got %d: %v", len(current), current)
+	}
+
+	if row := current["sip:10.0.0.1:5060"]; row == nil || row.attrs != "node=b;pod=kamailio-0" {
+		t.Errorf("expected attributes of 10.0.0.1 to be updated, got %+v", row)
+	} else if row.id != initial["sip:10.0.0.1:5060"].id {
+		t.Errorf("expected row of 10.0.0.1 to be updated in place, but it was replaced")
+	}
+
+	if _, ok := current["sip:10.0.0.2:5060"]; ok {
+		t.Error("expected departed member 10.0.0.2 to be deleted")
+	}
+
+	if row := current["sip:10.0.0.3:5060"]; row == nil || row.setid != 1 {
+		t.Errorf("expected new member 10.0.0.3 in set 1, got %+v", row)
+	}
+
+	// Sets which are not part of an export are not owned by it.
+	if row := current["sip:10.0.1.1:5080"]; row == nil || row.id != initial["sip:10.0.1.1:5080"].id {
+		t.Errorf("expected set 2 to be left alone, got %+v", row)
+	}
+
+	if row := current["sip:192.0.2.1:5060"]; row == nil || row.setid != 9 {
+		t.Errorf("expected unmanaged row to be left alone, got %+v", row)
+	}
+
+	// An export of an empty set removes all of its members.
+	if err = e.Export([]*sets.State{{ID: 1}}); err != nil {
+		t.Fatalf("third export failed: %v", err)
+	}
+
+	for dest, row := range readDispatcherRows(t, db) {
+		if row.setid == 1 {
+			t.Errorf("expected set 1 to be emptied, but found %s", dest)
+		}
+	}
+}
+
+func TestKamailioRTPEngineTable(t *testing.T) {
+	_, rows := KamailioRTPEngineTable.Rows([]*sets.State{
+		{
+			ID: 1,
+			Endpoints: []*sets.Endpoint{
+				{Address: "10.0.0.1", Port: 2223},
+				{Address: "fd00::1", Port: 2223},
+				{Address: "10.0.0.2", Port: 2223, Drained: true},
+			},
+		},
+	})
+
+	want := []string{"udp:10.0.0.1:2223", "udp6:[fd00::1]:2223", "udp:10.0.0.2:2223"}
+	disabled := []int{0, 0, 1}
+
+	if len(rows) != len(want) {
+		t.Fatalf("expected %d rows, got %d", len(want), len(rows))
+	}
+
+	for i, row := range rows {
+		if row[1] != want[i] {
+			t.Errorf("expected socket %q, got %q", want[i], row[1])
+		}
+
+		if row[3] != disabled[i] {
+			t.Errorf("expected socket %q to have disabled %d, got %v", want[i], disabled[i], row[3])
+		}
+	}
+}
+
+func TestSQLTablesDrained(t *testing.T) {
+	states := []*sets.State{
+		{
+			ID: 1,
+			Endpoints: []*sets.Endpoint{
+				{Address: "10.0.0.1", Port: 5060, NotReady: true},
+				{Address: "10.0.0.2", Port: 5060, Drained: true},
+			},
+		},
+	}
+
+	_, rows := KamailioDispatcherTable.Rows(states)
+
+	if len(rows) != 2 || rows[0][2] != 0 || rows[1][2] != 1 {
+		t.Errorf("expected only the drained member to be flagged as inactive, got %v", rows)
+	}
+
+	for name, table := range map[string]*SQLTable{
+		"lcr_gw":        KamailioLCRGatewayTable(nil),
+		"dr_gateways":   KamailioDRoutingGatewayTable(nil),
+		"load_balancer": OpenSIPSLoadBalancerTable("pstn=32"),
+	} {
+		_, rows = table.Rows(states)
+
+		if len(rows) != 1 {
+			t.Errorf("expected the drained member to be omitted from %s, got %v", name, rows)
+		}
+	}
+}
+
+func TestKamailioLCRGatewayTable(t *testing.T) {
+	table := KamailioLCRGatewayTable(map[int]int{1: 7})
+
+	wantColumns := []string{"lcr_id", "gw_name", "ip_addr", "port", "uri_scheme", "strip", "tag", "flags"}
+	if !reflect.DeepEqual(table.Columns, wantColumns) || table.KeyColumns != 2 {
+		t.Errorf("unexpected columns %v with %d key columns", table.Columns, table.KeyColumns)
+	}
+
+	groups, rows := table.Rows([]*sets.State{
+		{
+			ID: 1,
+			Endpoints: []*sets.Endpoint{
+				{Address: "10.0.0.1", Port: 5060, Attributes: map[string]string{"pod": "asterisk-0"}},
+				{Address: "fd00::1", Port: 5080},
+				{Address: "10.0.0.2", Port: 5060, Drained: true},
+			},
+		},
+		{
+			ID:        2,
+			Endpoints: []*sets.Endpoint{{Address: "10.0.1.1", Port: 5060, Attributes: map[string]string{"pod": "trunk-0"}}},
+		},
+	})
+
+	// Set 1 is mapped to LCR instance 7, while set 2 keeps its own ID.
+	if !reflect.DeepEqual(groups, []interface{}{7, 2}) {
+		t.Errorf("expected LCR instances 7 and 2, got %v", groups)
+	}
+
+	want := []SQLRow{
+		{7, "10.0.0.1:5060", "10.0.0.1", uint32(5060), 1, 0, "asterisk-0", 0},
+		{7, "[fd00::1]:5080", "fd00::1", uint32(5080), 1, 0, "", 0},
+		{2, "10.0.1.1:5060", "10.0.1.1", uint32(5060), 1, 0, "trunk-0", 0},
+	}
+
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("expected rows %v, got %v", want, rows)
+	}
+}
+
+func TestKamailioDRoutingGatewayTable(t *testing.T) {
+	table := KamailioDRoutingGatewayTable(map[int]int{1: 10})
+
+	wantColumns := []string{"type", "address", "strip", "pri_prefix", "attrs", "description"}
+	if !reflect.DeepEqual(table.Columns, wantColumns) || table.KeyColumns != 2 {
+		t.Errorf("unexpected columns %v with %d key columns", table.Columns, table.KeyColumns)
+	}
+
+	groups, rows := table.Rows([]*sets.State{
+		{
+			ID: 1,
+			Endpoints: []*sets.Endpoint{
+				{Address: "10.0.0.1", Port: 5060, Attributes: map[string]string{"pod": "asterisk-0", "zone": "a"}},
+				{Address: "10.0.0.2", Port: 5060, Drained: true},
+			},
+		},
+		{
+			ID:        2,
+			Endpoints: []*sets.Endpoint{{Address: "10.0.1.1", Port: 5060}},
+		},
+	})
+
+	// Set 1 is mapped to gateway type 10, while set 2 keeps its own ID.
+	if !reflect.DeepEqual(groups, []interface{}{10, 2}) {
+		t.Errorf("expected gateway types 10 and 2, got %v", groups)
+	}
+
+	want := []SQLRow{
+		{10, "10.0.0.1:5060", 0, "", sets.FormatAttributes(map[string]string{"pod": "asterisk-0", "zone": "a"}), "asterisk-0"},
+		{2, "10.0.1.1:5060", 0, "", "", ""},
+	}
+
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("expected rows %v, got %v", want, rows)
+	}
+}
+
+func TestSQLExporterLCRGateways(t *testing.T) {
+	db := newTestDB(t)
+
+	if _, err := db.Exec(`CREATE TABLE lcr_gw (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	lcr_id SMALLINT NOT NULL,
+	gw_name VARCHAR(128),
+	ip_addr VARCHAR(50),
+	hostname VARCHAR(64),
+	port SMALLINT,
+	params VARCHAR(64),
+	uri_scheme SMALLINT,
+	transport SMALLINT,
+	strip SMALLINT,
+	prefix VARCHAR(16) DEFAULT NULL,
+	tag VARCHAR(64) DEFAULT NULL,
+	flags INTEGER NOT NULL DEFAULT 0,
+	defunct INTEGER DEFAULT NULL
+)`); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+
+	// A gateway of an LCR instance which is not managed by the exporter.
+	if _, err := db.Exec(`INSERT INTO lcr_gw (lcr_id, gw_name, ip_addr, port) VALUES (9, 'carrier', '192.0.2.1', 5060)`); err != nil {
+		t.Fatalf("failed to insert row: %v", err)
+	}
+
+	e, err := NewSQLExporter(db, SQLite, KamailioLCRGatewayTable(map[int]int{1: 7}))
+	if err != nil {
+		t.Fatalf("failed to create exporter: %v", err)
+	}
+
+	export := func(tag string) {
+		t.Helper()
+
+		if err := e.Export([]*sets.State{{ID: 1, Endpoints: []*sets.Endpoint{{Address: "10.0.0.1", Port: 5060, Attributes: map[string]string{"pod": tag}}}}}); err != nil {
+			t.Fatalf("export failed: %v", err)
+		}
+	}
+
+	export("asterisk-0")
+	export("asterisk-1")
+
+	rows, err := db.Query("SELECT lcr_id, gw_name, ip_addr, port, COALESCE(tag, '') FROM lcr_gw ORDER BY lcr_id")
+	if err != nil {
+		t.Fatalf("failed to query table: %v", err)
+	}
+	defer rows.Close()
+
+	var got []string
+
+	for rows.Next() {
+		var lcrID, port int
+		var name, addr, tag string
+
+		if err = rows.Scan(&lcrID, &name, &addr, &port, &tag); err != nil {
+			t.Fatalf("failed to read row: %v", err)
+		}
+
+		got = append(got, fmt.Sprintf("%d %s %s %d %s", lcrID, name, addr, port, tag))
+	}
+
+	want := []string{"7 10.0.0.1:5060 10.0.0.1 5060 asterisk-1", "9 carrier 192.0.2.1 5060 "}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected gateways %v, got %v", want, got)
+	}
+}
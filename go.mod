@@ -4,7 +4,15 @@ go 1.16
 
 require (
 	github.com/CyCoreSystems/go-kamailio v0.2.1
+	github.com/go-sql-driver/mysql v1.6.0
+	github.com/lib/pq v1.10.2
+	github.com/mattn/go-sqlite3 v1.14.6
+	github.com/prometheus/client_model v0.2.0
+	github.com/prometheus/common v0.25.0
+	golang.org/x/time v0.0.0-20210220033141-f8bda1e9f3ba
 	inet.af/netaddr v0.0.0-20210526175434-db50905a50be
 	k8s.io/api v0.21.1
+	k8s.io/apimachinery v0.21.1
 	k8s.io/client-go v0.21.1
+	sigs.k8s.io/yaml v1.2.0
 )
@@ -0,0 +1,225 @@
+// Package metrics exposes Prometheus metrics describing the activity of a dispatchers.Controller.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/CyCoreSystems/dispatchers/v2"
+	"github.com/CyCoreSystems/dispatchers/v2/sets"
+)
+
+// ContentType is the content type of the Prometheus text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DurationBuckets are the upper bounds, in seconds, of the buckets of the export and notification duration histograms.
+var DurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// histogram is a minimal Prometheus histogram.
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram() *histogram {
+	return &histogram{
+		counts: make([]uint64, len(DurationBuckets)),
+	}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, b := range DurationBuckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// Collector is a dispatchers.Observer which collects the activity of a Controller and serves it as Prometheus metrics.
+type Collector struct {
+	c *dispatchers.Controller
+
+	setChanges map[int]uint64
+
+	exports        uint64
+	exportFailures uint64
+	exportDuration *histogram
+	lastExport     time.Time
+
+	notifications        uint64
+	notificationFailures uint64
+	notificationDuration *histogram
+	lastNotification     time.Time
+
+	mu sync.Mutex
+}
+
+// New creates a new Collector for the given Controller.
+// The Collector must also be installed as (or among) the Observer of the Controller in order to collect its activity.
+func New(c *dispatchers.Controller) *Collector {
+	return &Collector{
+		c:                    c,
+		setChanges:           make(map[int]uint64),
+		exportDuration:       newHistogram(),
+		notificationDuration: newHistogram(),
+	}
+}
+
+// SetChanged implements dispatchers.Observer
+func (m *Collector) SetChanged(state *sets.State) {
+	m.mu.Lock()
+	m.setChanges[state.ID]++
+	m.mu.Unlock()
+}
+
+// Exported implements dispatchers.Observer
+func (m *Collector) Exported(duration time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.exports++
+	m.exportDuration.observe(duration.Seconds())
+
+	if err != nil {
+		m.exportFailures++
+		return
+	}
+
+	m.lastExport = time.Now()
+}
+
+// Notified implements dispatchers.Observer
+func (m *Collector) Notified(duration time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.notifications++
+	m.notificationDuration.observe(duration.Seconds())
+
+	if err != nil {
+		m.notificationFailures++
+		return
+	}
+
+	m.lastNotification = time.Now()
+}
+
+// ServeHTTP implements http.Handler, serving the metrics in the Prometheus text exposition format.
+func (m *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+
+	buf := bufio.NewWriter(w)
+	m.write(buf)
+	buf.Flush()
+}
+
+func (m *Collector) write(w *bufio.Writer) {
+	dispatcherSets := m.c.Sets()
+
+	states := make([]*sets.State, 0, len(dispatcherSets))
+	for _, s := range dispatcherSets {
+		states = append(states, s.State())
+	}
+
+	header(w, "dispatchers_set_members", "gauge", "Number of members of each dispatcher set, by readiness.")
+	for _, s := range states {
+		var ready, notReady int
+		for _, ep := range s.Endpoints {
+			if ep.NotReady {
+				notReady++
+			} else {
+				ready++
+			}
+		}
+
+		fmt.Fprintf(w, "dispatchers_set_members{set=\"%d\",ready=\"true\"} %d\n", s.ID, ready)
+		fmt.Fprintf(w, "dispatchers_set_members{set=\"%d\",ready=\"false\"} %d\n", s.ID, notReady)
+	}
+
+	header(w, "dispatchers_set_synced", "gauge", "Whether the initial membership of each dispatcher set has been loaded (1) or not (0).")
+	for i, s := range dispatcherSets {
+		fmt.Fprintf(w, "dispatchers_set_synced{set=\"%d\"} %d\n", states[i].ID, boolValue(sets.HasSynced(s)))
+	}
+
+	header(w, "dispatchers_revision", "gauge", "Current revision of the dispatcher sets.")
+	fmt.Fprintf(w, "dispatchers_revision %d\n", m.c.Revision())
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	header(w, "dispatchers_set_changes_total", "counter", "Number of changes of each dispatcher set.")
+	ids := make([]int, 0, len(m.setChanges))
+	for id := range m.setChanges {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		fmt.Fprintf(w, "dispatchers_set_changes_total{set=\"%d\"} %d\n", id, m.setChanges[id])
+	}
+
+	header(w, "dispatchers_exports_total", "counter", "Number of exports attempted.")
+	fmt.Fprintf(w, "dispatchers_exports_total %d\n", m.exports)
+
+	header(w, "dispatchers_export_failures_total", "counter", "Number of exports which failed.")
+	fmt.Fprintf(w, "dispatchers_export_failures_total %d\n", m.exportFailures)
+
+	writeHistogram(w, "dispatchers_export_duration_seconds", "Duration of exports.", m.exportDuration)
+
+	header(w, "dispatchers_last_export_success_timestamp_seconds", "gauge", "Time of the last successful export.")
+	fmt.Fprintf(w, "dispatchers_last_export_success_timestamp_seconds %s\n", timestamp(m.lastExport))
+
+	header(w, "dispatchers_notifications_total", "counter", "Number of notifications (such as reloads of kamailio) attempted.")
+	fmt.Fprintf(w, "dispatchers_notifications_total %d\n", m.notifications)
+
+	header(w, "dispatchers_notification_failures_total", "counter", "Number of notifications which failed.")
+	fmt.Fprintf(w, "dispatchers_notification_failures_total %d\n", m.notificationFailures)
+
+	writeHistogram(w, "dispatchers_notification_duration_seconds", "Duration of notifications.", m.notificationDuration)
+
+	header(w, "dispatchers_last_reload_success_timestamp_seconds", "gauge", "Time of the last successful notification (such as a reload of kamailio).")
+	fmt.Fprintf(w, "dispatchers_last_reload_success_timestamp_seconds %s\n", timestamp(m.lastNotification))
+}
+
+func header(w *bufio.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+func writeHistogram(w *bufio.Writer, name, help string, h *histogram) {
+	header(w, name, "histogram", help)
+
+	for i, b := range DurationBuckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, formatFloat(b), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func timestamp(t time.Time) string {
+	if t.IsZero() {
+		return "0"
+	}
+
+	return formatFloat(float64(t.UnixNano()) / 1e9)
+}
+
+func boolValue(b bool) int {
+	if b {
+		return 1
+	}
+
+	return 0
+}
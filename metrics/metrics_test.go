@@ -0,0 +1,150 @@
+package metrics
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/CyCoreSystems/dispatchers/v2"
+	"github.com/CyCoreSystems/dispatchers/v2/sets"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestCollector(t *testing.T) {
+	c := new(dispatchers.Controller)
+	c.AddSet(sets.NewStaticSet(1, []*sets.Endpoint{
+		{Address: "10.0.0.1", Port: 5060},
+		{Address: "10.0.0.2", Port: 5060, NotReady: true},
+		{Address: "10.0.0.3", Port: 5060},
+	}))
+
+	m := New(c)
+
+	m.SetChanged(&sets.State{ID: 1})
+	m.SetChanged(&sets.State{ID: 1})
+	m.Exported(20*time.Millisecond, nil)
+	m.Exported(3*time.Second, errors.New("failed"))
+	m.Notified(time.Millisecond, nil)
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("expected content type %q, got %q", ContentType, ct)
+	}
+
+	var parser expfmt.TextParser
+
+	families, err := parser.TextToMetricFamilies(rec.Body)
+	if err != nil {
+		t.Fatalf("failed to parse metrics: %v", err)
+	}
+
+	types := map[string]dto.MetricType{
+		"dispatchers_set_members":                           dto.MetricType_GAUGE,
+		"dispatchers_set_synced":                            dto.MetricType_GAUGE,
+		"dispatchers_revision":                              dto.MetricType_GAUGE,
+		"dispatchers_set_changes_total":                     dto.MetricType_COUNTER,
+		"dispatchers_exports_total":                         dto.MetricType_COUNTER,
+		"dispatchers_export_failures_total":                 dto.MetricType_COUNTER,
+		"dispatchers_export_duration_seconds":               dto.MetricType_HISTOGRAM,
+		"dispatchers_last_export_success_timestamp_seconds": dto.MetricType_GAUGE,
+		"dispatchers_notifications_total":                   dto.MetricType_COUNTER,
+		"dispatchers_notification_failures_total":           dto.MetricType_COUNTER,
+		"dispatchers_notification_duration_seconds":         dto.MetricType_HISTOGRAM,
+		"dispatchers_last_reload_success_timestamp_seconds": dto.MetricType_GAUGE,
+	}
+
+	for name, kind := range types {
+		f, ok := families[name]
+		if !ok {
+			t.Errorf("metric %s is missing", name)
+			continue
+		}
+
+		if f.GetType() != kind {
+			t.Errorf("expected %s to be a %s, got %s", name, kind, f.GetType())
+		}
+
+		if f.GetHelp() == "" {
+			t.Errorf("expected %s to have a help text", name)
+		}
+	}
+
+	members := make(map[string]float64)
+	for _, metric := range families["dispatchers_set_members"].GetMetric() {
+		members[label(metric, "ready")] = metric.GetGauge().GetValue()
+	}
+
+	if members["true"] != 2 || members["false"] != 1 {
+		t.Errorf("expected 2 ready and 1 not-ready members, got %v", members)
+	}
+
+	if v := value(families["dispatchers_set_changes_total"]); v != 2 {
+		t.Errorf("expected 2 set changes, got %v", v)
+	}
+
+	if v := value(families["dispatchers_exports_total"]); v != 2 {
+		t.Errorf("expected 2 exports, got %v", v)
+	}
+
+	if v := value(families["dispatchers_export_failures_total"]); v != 1 {
+		t.Errorf("expected 1 export failure, got %v", v)
+	}
+
+	if v := value(families["dispatchers_last_export_success_timestamp_seconds"]); v == 0 {
+		t.Error("expected the time of the last export to be set")
+	}
+
+	h := families["dispatchers_export_duration_seconds"].GetMetric()[0].GetHistogram()
+
+	if h.GetSampleCount() != 2 {
+		t.Errorf("expected 2 export durations, got %d", h.GetSampleCount())
+	}
+
+	// The parser keeps the +Inf bucket.
+	buckets := h.GetBucket()
+	if len(buckets) != len(DurationBuckets)+1 {
+		t.Fatalf("expected %d buckets, got %d", len(DurationBuckets)+1, len(buckets))
+	}
+
+	for _, b := range buckets {
+		var want uint64
+		if b.GetUpperBound() >= 0.02 {
+			want++
+		}
+		if b.GetUpperBound() >= 3 {
+			want++
+		}
+
+		if b.GetCumulativeCount() != want {
+			t.Errorf("expected bucket %v to count %d, got %d", b.GetUpperBound(), want, b.GetCumulativeCount())
+		}
+	}
+}
+
+func label(m *dto.Metric, name string) string {
+	for _, l := range m.GetLabel() {
+		if l.GetName() == name {
+			return l.GetValue()
+		}
+	}
+
+	return ""
+}
+
+// value returns the value of the first metric of a gauge or counter family.
+func value(f *dto.MetricFamily) float64 {
+	if f == nil || len(f.GetMetric()) == 0 {
+		return 0
+	}
+
+	m := f.GetMetric()[0]
+	if m.GetCounter() != nil {
+		return m.GetCounter().GetValue()
+	}
+
+	return m.GetGauge().GetValue()
+}
@@ -0,0 +1,159 @@
+package notifier
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/CyCoreSystems/dispatchers/v2/sets"
+)
+
+// PJSIPReload is the Asterisk CLI command which reloads the PJSIP configuration.
+const PJSIPReload = "pjsip reload"
+
+var amiActionID int64
+
+// AMINotifier is a dispatchers.Notifier which tells Asterisk to reload its configuration by running a CLI command through the Asterisk Manager Interface (AMI).
+type AMINotifier struct {
+
+	// Address is the host:port of the AMI service, such as "127.0.0.1:5038".
+	Address string
+
+	// Username is the AMI username, which requires the `command` write permission.
+	Username string
+
+	// Secret is the password of the AMI user.
+	Secret string
+
+	// Command is the CLI command to run.  If not specified, PJSIPReload is used.
+	Command string
+
+	// Timeout is the amount of time to wait for Asterisk to complete the command.  If not specified, DefaultTimeout is used.
+	Timeout time.Duration
+}
+
+// Notify implements dispatchers.Notifier
+func (n *AMINotifier) Notify(sets []*sets.State) error {
+	timeout := n.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+
+	command := n.Command
+	if command == "" {
+		command = PJSIPReload
+	}
+
+	conn, err := net.DialTimeout("tcp", n.Address, timeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect to AMI at %s: %w", n.Address, err)
+	}
+	defer conn.Close()
+
+	if err = conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return fmt.Errorf("failed to set deadline: %w", err)
+	}
+
+	r := bufio.NewReader(conn)
+
+	// Asterisk identifies itself with a single line upon connection.
+	if _, err = r.ReadString('\n'); err != nil {
+		return fmt.Errorf("failed to read AMI greeting: %w", err)
+	}
+
+	if _, err = amiAction(conn, r, "Login", "Username", n.Username, "Secret", n.Secret, "Events", "off"); err != nil {
+		return fmt.Errorf("failed to log in to AMI: %w", err)
+	}
+
+	if _, err = amiAction(conn, r, "Command", "Command", command); err != nil {
+		return fmt.Errorf("failed to run %q: %w", command, err)
+	}
+
+	amiAction(conn, r, "Logoff") // nolint: errcheck
+
+	return nil
+}
+
+// amiAction sends an AMI action with the given header key/value pairs and waits for its successful response.
+func amiAction(conn net.Conn, r *bufio.Reader, action string, headers ...string) (map[string]string, error) {
+	id := strconv.FormatInt(atomic.AddInt64(&amiActionID, 1), 10)
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Action: %s\r\nActionID: %s\r\n", action, id)
+	for i := 0; i+1 < len(headers); i += 2 {
+		fmt.Fprintf(&b, "%s: %s\r\n", headers[i], headers[i+1])
+	}
+	b.WriteString("\r\n")
+
+	if _, err := conn.Write([]byte(b.String())); err != nil {
+		return nil, err
+	}
+
+	for {
+		msg, err := readAMIMessage(r)
+		if err != nil {
+			return nil, err
+		}
+
+		// Skip any events and responses to other actions
+		if msg["ActionID"] != id || msg["Response"] == "" {
+			continue
+		}
+
+		switch msg["Response"] {
+		case "Success", "Follows", "Goodbye":
+			return msg, nil
+		default:
+			return msg, fmt.Errorf("%s: %s", msg["Response"], msg["Message"])
+		}
+	}
+}
+
+// readAMIMessage reads a single AMI message, which is terminated by an empty line.
+// The output of an old-style command response ("Response: Follows") may contain empty lines, so it is instead terminated by an end-of-command marker.
+func readAMIMessage(r *bufio.Reader) (map[string]string, error) {
+	msg := make(map[string]string)
+
+	var inOutput bool
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+
+		if strings.HasPrefix(line, "--END COMMAND--") {
+			inOutput = false
+			continue
+		}
+
+		if line == "" {
+			if inOutput {
+				continue
+			}
+			return msg, nil
+		}
+
+		if inOutput {
+			continue
+		}
+
+		pieces := strings.SplitN(line, ":", 2)
+		if len(pieces) != 2 {
+			continue
+		}
+
+		msg[pieces[0]] = strings.TrimSpace(pieces[1])
+
+		if pieces[0] == "ActionID" && msg["Response"] == "Follows" {
+			inOutput = true
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package notifier
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// amiStub is a minimal Asterisk Manager Interface server which accepts a single connection and records the actions it receives.
+type amiStub struct {
+	listener net.Listener
+
+	// respond returns the response headers to the given action.
+	respond func(action map[string]string) []string
+
+	actions chan map[string]string
+}
+
+func newAMIStub(t *testing.T, respond func(action map[string]string) []string) *amiStub {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	s := &amiStub{
+		listener: l,
+		respond:  respond,
+		actions:  make(chan map[string]string, 10),
+	}
+
+	t.Cleanup(func() { l.Close() })
+
+	go s.serve()
+
+	return s
+}
+
+func (s *amiStub) serve() {
+	defer close(s.actions)
+
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	fmt.Fprint(conn, "Asterisk Call Manager/7.0.3\r\n")
+
+	r := bufio.NewReader(conn)
+
+	for {
+		action, err := readAMIMessage(r)
+		if err != nil {
+			return
+		}
+
+		s.actions <- action
+
+		var b strings.Builder
+		for _, line := range s.respond(action) {
+			b.WriteString(line + "\r\n")
+		}
+		fmt.Fprintf(&b, "ActionID: %s\r\n\r\n", action["ActionID"])
+
+		if _, err = conn.Write([]byte(b.String())); err != nil {
+			return
+		}
+
+		if action["Action"] == "Logoff" {
+			return
+		}
+	}
+}
+
+func (s *amiStub) received() (list []map[string]string) {
+	for action := range s.actions {
+		list = append(list, action)
+	}
+
+	return list
+}
+
+func TestAMINotifier(t *testing.T) {
+	stub := newAMIStub(t, func(action map[string]string) []string {
+		switch action["Action"] {
+		case "Login":
+			if action["Username"] != "dispatchers" || action["Secret"] != "s3cret" {
+				return []string{"Response: Error", "Message: Authentication failed"}
+			}
+			return []string{"Response: Success", "Message: Authentication accepted"}
+		case "Command":
+			return []string{"Response: Success", "Message: Command output follows", "Output: Module 'res_pjsip.so' reloaded"}
+		case "Logoff":
+			return []string{"Response: Goodbye", "Message: Thanks for all the fish."}
+		default:
+			return []string{"Response: Error", "Message: Invalid/unknown command"}
+		}
+	})
+
+	n := &AMINotifier{
+		Address:  stub.listener.Addr().String(),
+		Username: "dispatchers",
+		Secret:   "s3cret",
+		Timeout:  5 * time.Second,
+	}
+
+	if err := n.Notify(nil); err != nil {
+		t.Fatalf("notification failed: %v", err)
+	}
+
+	actions := stub.received()
+	if len(actions) != 3 {
+		t.Fatalf("expected 3 actions, got %d: %v", len(actions), actions)
+	}
+
+	for i, name := range []string{"Login", "Command", "Logoff"} {
+		if actions[i]["Action"] != name {
+			t.Errorf("expected action %d to be %s, got %s", i, name, actions[i]["Action"])
+		}
+	}
+
+	if actions[0]["Events"] != "off" {
+		t.Errorf("expected events to be disabled on login, got %q", actions[0]["Events"])
+	}
+
+	if actions[1]["Command"] != PJSIPReload {
+		t.Errorf("expected command %q, got %q", PJSIPReload, actions[1]["Command"])
+	}
+}
+
+func TestAMINotifierLoginFailure(t *testing.T) {
+	stub := newAMIStub(t, func(action map[string]string) []string {
+		return []string{"Response: Error", "Message: Authentication failed"}
+	})
+
+	n := &AMINotifier{
+		Address:  stub.listener.Addr().String(),
+		Username: "dispatchers",
+		Secret:   "wrong",
+		Timeout:  5 * time.Second,
+	}
+
+	err := n.Notify(nil)
+	if err == nil || !strings.Contains(err.Error(), "Authentication failed") {
+		t.Fatalf("expected an authentication failure, got %v", err)
+	}
+
+	for _, action := range stub.received() {
+		if action["Action"] == "Command" {
+			t.Error("command was sent despite the failed login")
+		}
+	}
+}
+
+func TestAMINotifierCommandFailure(t *testing.T) {
+	stub := newAMIStub(t, func(action map[string]string) []string {
+		if action["Action"] == "Command" {
+			return []string{"Response: Error", "Message: Permission denied"}
+		}
+		return []string{"Response: Success"}
+	})
+
+	n := &AMINotifier{
+		Address: stub.listener.Addr().String(),
+		Command: "module reload res_pjsip.so",
+		Timeout: 5 * time.Second,
+	}
+
+	err := n.Notify(nil)
+	if err == nil || !strings.Contains(err.Error(), "Permission denied") {
+		t.Fatalf("expected the command to fail, got %v", err)
+	}
+
+	actions := stub.received()
+	if len(actions) < 2 || actions[1]["Command"] != "module reload res_pjsip.so" {
+		t.Fatalf("expected the configured command to be sent, got %v", actions)
+	}
+}
+
+func TestAMINotifierTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer l.Close()
+
+	// Accept the connection, but never send a greeting.
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(time.Second)
+		}
+	}()
+
+	n := &AMINotifier{
+		Address: l.Addr().String(),
+		Timeout: 100 * time.Millisecond,
+	}
+
+	if err := n.Notify(nil); err == nil {
+		t.Fatal("expected the notification to time out")
+	}
+}
@@ -5,7 +5,24 @@ import (
 	"github.com/CyCoreSystems/go-kamailio/binrpc"
 )
 
-// BinRPCNotifier is a dispatchers.Notifier which tells Kamailio to reload its dispatcher module using the binrpc protocol.
+const (
+	// DispatcherReload is the kamailio RPC method which reloads the dispatcher module.
+	DispatcherReload = "dispatcher.reload"
+
+	// PermissionsAddressReload is the kamailio RPC method which reloads the address table of the permissions module.
+	PermissionsAddressReload = "permissions.addressReload"
+
+	// RTPEngineReload is the kamailio RPC method which reloads the rtpengine sets of the rtpengine module from its database table.
+	RTPEngineReload = "rtpengine.reload"
+
+	// LCRReload is the kamailio RPC method which reloads the gateways and rules of the lcr module.
+	LCRReload = "lcr.reload"
+
+	// DRoutingReload is the kamailio RPC method which reloads the routing data of the drouting module.
+	DRoutingReload = "drouting.reload"
+)
+
+// BinRPCNotifier is a dispatchers.Notifier which tells Kamailio to reload its dispatcher module (or another module, using Method) using the binrpc protocol.
 type BinRPCNotifier struct{
 
 	// Host is the Kamailio hostname or IP address.
@@ -13,9 +30,17 @@ type BinRPCNotifier struct{
 
 	// Port is the UDP port on which Kamailio is listening for binrpc.
 	Port string
+
+	// Method is the RPC method to invoke.  If not specified, DispatcherReload is used.
+	Method string
 }
 
 // Notify implements dispatchers.Notifier
 func (b *BinRPCNotifier) Notify(sets []*sets.State) error {
-	return binrpc.InvokeMethod("dispatcher.reload", b.Host, b.Port)
+	method := b.Method
+	if method == "" {
+		method = DispatcherReload
+	}
+
+	return binrpc.InvokeMethod(method, b.Host, b.Port)
 }
@@ -0,0 +1,67 @@
+package notifier
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CyCoreSystems/dispatchers/v2/exporter"
+	"github.com/CyCoreSystems/dispatchers/v2/sets"
+)
+
+// DMQRemove is the kamailio RPC method which removes a node from the DMQ peer list.
+const DMQRemove = "dmq.remove"
+
+// DMQNotifier is a dispatchers.Notifier which keeps the DMQ peers of a kamailio instance current.
+// Whenever a member leaves the dispatcher sets, kamailio is told to remove it as a DMQ node, using the JSON-RPC interface of its jsonrpcs module over HTTP.
+// Members which join the sets announce themselves through DMQ, so no action is required for them.
+type DMQNotifier struct {
+
+	// URL is the address of the kamailio JSON-RPC HTTP interface, such as "http://127.0.0.1:5060/RPC".
+	URL string
+
+	// Local is the address of the local kamailio instance, which is never removed.
+	Local string
+
+	// Timeout is the amount of time to wait for kamailio to respond.  If not specified, DefaultTimeout is used.
+	Timeout time.Duration
+
+	peers map[string]bool
+
+	mu sync.Mutex
+}
+
+// Notify implements dispatchers.Notifier
+func (n *DMQNotifier) Notify(sets []*sets.State) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	current := make(map[string]bool)
+	for _, peer := range exporter.DMQPeers(sets, n.Local) {
+		current[peer] = true
+	}
+
+	var errs []string
+
+	for peer := range n.peers {
+		if current[peer] {
+			continue
+		}
+
+		if _, err := invokeJSONRPCHTTP(n.URL, n.Timeout, DMQRemove, peer); err != nil {
+			errs = append(errs, err.Error())
+
+			// Retry the removal on the next notification
+			current[peer] = true
+		}
+	}
+
+	n.peers = current
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to remove DMQ peers: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package notifier
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/CyCoreSystems/dispatchers/v2/sets"
+)
+
+// jsonrpcStub is a kamailio JSON-RPC HTTP interface which records the parameters of the dmq.remove requests it receives, failing those for the peers in fail.
+type jsonrpcStub struct {
+	fail    map[string]bool
+	removed []string
+
+	mu sync.Mutex
+}
+
+func (s *jsonrpcStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req := new(jsonrpcRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil || req.Method != DMQRemove || len(req.Params) != 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	peer, _ := req.Params[0].(string) // nolint: errcheck
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resp := &jsonrpcResponse{Version: "2.0", ID: req.ID}
+
+	if s.fail[peer] {
+		resp.Error = &jsonrpcError{Code: 500, Message: "node not found"}
+	} else {
+		s.removed = append(s.removed, peer)
+	}
+
+	json.NewEncoder(w).Encode(resp) // nolint: errcheck
+}
+
+func (s *jsonrpcStub) takeRemoved() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := s.removed
+	s.removed = nil
+
+	sort.Strings(out)
+
+	return out
+}
+
+func TestDMQNotifier(t *testing.T) {
+	stub := &jsonrpcStub{fail: map[string]bool{"sip:10.0.0.3:5060": true}}
+
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+
+	// The local instance is given in another form than its address in the set.
+	n := &DMQNotifier{URL: srv.URL, Local: "::ffff:10.0.0.1"}
+
+	members := func(addrs ...string) []*sets.State {
+		state := &sets.State{ID: 0}
+
+		for _, addr := range addrs {
+			state.Endpoints = append(state.Endpoints, &sets.Endpoint{Address: addr, Port: 5060})
+		}
+
+		return []*sets.State{state}
+	}
+
+	if err := n.Notify(members("10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4")); err != nil {
+		t.Fatalf("first notification failed: %v", err)
+	}
+
+	if removed := stub.takeRemoved(); len(removed) != 0 {
+		t.Errorf("expected no removals, got %v", removed)
+	}
+
+	// The departed peers are removed, except for the local instance, and the failed removal of 10.0.0.3 is reported.
+	if err := n.Notify(members("10.0.0.4")); err == nil {
+		t.Error("expected the failed removal to be reported")
+	}
+
+	if removed := stub.takeRemoved(); len(removed) != 1 || removed[0] != "sip:10.0.0.2:5060" {
+		t.Errorf("expected the removal of 10.0.0.2 only, got %v", removed)
+	}
+
+	// The failed removal is retried on the next notification.
+	stub.mu.Lock()
+	stub.fail = nil
+	stub.mu.Unlock()
+
+	if err := n.Notify(members("10.0.0.4")); err != nil {
+		t.Fatalf("third notification failed: %v", err)
+	}
+
+	if removed := stub.takeRemoved(); len(removed) != 1 || removed[0] != "sip:10.0.0.3:5060" {
+		t.Errorf("expected the retried removal of 10.0.0.3, got %v", removed)
+	}
+
+	if err := n.Notify(members("10.0.0.4")); err != nil {
+		t.Fatalf("fourth notification failed: %v", err)
+	}
+
+	if removed := stub.takeRemoved(); len(removed) != 0 {
+		t.Errorf("expected no further removals, got %v", removed)
+	}
+}
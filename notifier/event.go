@@ -0,0 +1,236 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CyCoreSystems/dispatchers/v2/sets"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// Reasons of the Kubernetes Events emitted by the EventNotifier.
+const (
+	EventReasonSetChanged   = "DispatcherSetChanged"
+	EventReasonExportFailed = "ExportFailed"
+	EventReasonReloadFailed = "ReloadFailed"
+)
+
+// EventLookupTimeout is the maximum amount of time to wait for the Kubernetes API to return the object on which an event is emitted.
+var EventLookupTimeout = 5 * time.Second
+
+// EventLookupRetryInterval is the amount of time for which a failed lookup of the UID of an object is remembered, during which events are emitted on the object without its UID rather than looking it up again.
+var EventLookupRetryInterval = time.Minute
+
+// eventQueueLength is the number of events which may wait to be emitted before further events are dropped.
+const eventQueueLength = 100
+
+// EventNotifier is a dispatchers.Observer which emits Kubernetes Events describing the activity of a Controller:
+// a Normal event whenever a dispatcher set changes, and a Warning event whenever an export or notification (such as a reload of kamailio) fails.
+//
+// Tools such as `kubectl describe` find the events of an object by its UID, so the UID of any Pod or Service reference which lacks one is looked up before an event is emitted on it.
+// Events are queued and emitted in the background, so that the Controller is never held up by the Kubernetes API;  if the queue is full, the event is dropped.
+type EventNotifier struct {
+	kc kubernetes.Interface
+
+	broadcaster record.EventBroadcaster
+	recorder    record.EventRecorder
+
+	object     *v1.ObjectReference
+	setObjects map[int]*v1.ObjectReference
+
+	// uids holds the UIDs which have been looked up, keyed by kind, namespace, and name.
+	uids map[string]types.UID
+
+	// failures holds the times of the most recent failed lookups of the UIDs which have not been found, keyed as uids.
+	failures map[string]time.Time
+
+	queue   chan *queuedEvent
+	done    chan struct{}
+	stopped chan struct{}
+
+	closeOnce sync.Once
+}
+
+// queuedEvent is an event which is waiting to be emitted.
+type queuedEvent struct {
+	ref       *v1.ObjectReference
+	eventType string
+	reason    string
+	message   string
+}
+
+// eventf queues an event for emission.
+func (n *EventNotifier) eventf(ref *v1.ObjectReference, eventType, reason, messageFmt string, args ...interface{}) {
+	ev := &queuedEvent{
+		ref:       ref,
+		eventType: eventType,
+		reason:    reason,
+		message:   fmt.Sprintf(messageFmt, args...),
+	}
+
+	select {
+	case <-n.done:
+	case n.queue <- ev:
+	default:
+		// The queue is full;  like the event broadcaster itself, drop the event rather than wait.
+	}
+}
+
+// run emits the queued events until the notifier is closed, then emits those still queued.
+func (n *EventNotifier) run() {
+	defer close(n.stopped)
+
+	for {
+		select {
+		case ev := <-n.queue:
+			n.emit(ev)
+		case <-n.done:
+			for {
+				select {
+				case ev := <-n.queue:
+					n.emit(ev)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (n *EventNotifier) emit(ev *queuedEvent) {
+	n.recorder.Event(n.resolve(ev.ref), ev.eventType, ev.reason, ev.message)
+}
+
+// resolve returns the given reference with its UID, looking it up if it is not set.
+// If the UID cannot be found, the reference is returned as it is, so that the event is still recorded, and the lookup is not retried for EventLookupRetryInterval.
+// It is only called by run, so it needs no locking.
+func (n *EventNotifier) resolve(ref *v1.ObjectReference) *v1.ObjectReference {
+	if ref.UID != "" {
+		return ref
+	}
+
+	key := ref.Kind + "/" + ref.Namespace + "/" + ref.Name
+
+	uid, ok := n.uids[key]
+	if !ok {
+		if failed, ok := n.failures[key]; ok && time.Since(failed) < EventLookupRetryInterval {
+			return ref
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), EventLookupTimeout)
+		defer cancel()
+
+		var meta metav1.Object
+		var err error
+
+		switch ref.Kind {
+		case "Pod":
+			meta, err = n.kc.CoreV1().Pods(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		case "Service":
+			meta, err = n.kc.CoreV1().Services(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		default:
+			return ref
+		}
+		if err != nil {
+			// The object may not exist yet;  try again once the retry interval has passed.
+			n.failures[key] = time.Now()
+			return ref
+		}
+
+		uid = meta.GetUID()
+
+		n.uids[key] = uid
+		delete(n.failures, key)
+	}
+
+	resolved := ref.DeepCopy()
+	resolved.UID = uid
+
+	return resolved
+}
+
+// SetChanged implements dispatchers.Observer
+func (n *EventNotifier) SetChanged(state *sets.State) {
+	obj := n.object
+	if ref, ok := n.setObjects[state.ID]; ok {
+		obj = ref
+	}
+
+	var members []string
+	for _, ep := range state.Endpoints {
+		members = append(members, ep.String())
+	}
+
+	n.eventf(obj, v1.EventTypeNormal, EventReasonSetChanged, "dispatcher set %d now has %d members: %s", state.ID, len(members), strings.Join(members, ", "))
+}
+
+// Exported implements dispatchers.Observer
+func (n *EventNotifier) Exported(duration time.Duration, err error) {
+	if err != nil {
+		n.eventf(n.object, v1.EventTypeWarning, EventReasonExportFailed, "failed to export dispatcher sets: %v", err)
+	}
+}
+
+// Notified implements dispatchers.Observer
+func (n *EventNotifier) Notified(duration time.Duration, err error) {
+	if err != nil {
+		n.eventf(n.object, v1.EventTypeWarning, EventReasonReloadFailed, "failed to notify of dispatcher set changes: %v", err)
+	}
+}
+
+// Close stops the emission of events, once those which are already queued have been emitted.
+func (n *EventNotifier) Close() {
+	n.closeOnce.Do(func() {
+		close(n.done)
+	})
+
+	<-n.stopped
+
+	n.broadcaster.Shutdown()
+}
+
+// NewEventNotifier creates a new dispatchers.Observer which emits Kubernetes Events on the given object, typically the kamailio Pod.
+// setObjects is optional and maps dispatcher set IDs to the objects, typically the source Services, on which the change events of those sets should instead be emitted.
+// The UIDs of Pod and Service references which lack them are looked up, which requires `get` access to them.
+func NewEventNotifier(kc kubernetes.Interface, object *v1.ObjectReference, setObjects map[int]*v1.ObjectReference) (*EventNotifier, error) {
+	if kc == nil {
+		return nil, fmt.Errorf("kubernetes client is nil")
+	}
+
+	if object == nil {
+		return nil, fmt.Errorf("object is nil")
+	}
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{
+		Interface: kc.CoreV1().Events(""),
+	})
+
+	n := &EventNotifier{
+		kc:          kc,
+		broadcaster: broadcaster,
+		recorder: broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{
+			Component: "dispatchers",
+		}),
+		object:     object,
+		setObjects: setObjects,
+		uids:       make(map[string]types.UID),
+		failures:   make(map[string]time.Time),
+		queue:      make(chan *queuedEvent, eventQueueLength),
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+
+	go n.run()
+
+	return n, nil
+}
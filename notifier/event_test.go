@@ -0,0 +1,125 @@
+package notifier
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CyCoreSystems/dispatchers/v2/sets"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestEventNotifierUIDs(t *testing.T) {
+	kc := fake.NewSimpleClientset(
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "voice", Name: "kamailio-0", UID: "pod-uid"}},
+		&v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "voice", Name: "asterisk", UID: "service-uid"}},
+	)
+
+	n, err := NewEventNotifier(kc,
+		&v1.ObjectReference{APIVersion: "v1", Kind: "Pod", Namespace: "voice", Name: "kamailio-0"},
+		map[int]*v1.ObjectReference{
+			1: {APIVersion: "v1", Kind: "Service", Namespace: "voice", Name: "asterisk"},
+		},
+	)
+	if err != nil {
+		t.Fatalf("failed to create notifier: %v", err)
+	}
+	defer n.Close()
+
+	n.SetChanged(&sets.State{ID: 1, Endpoints: []*sets.Endpoint{{Address: "10.0.0.1", Port: 5060}}})
+	n.Exported(time.Millisecond, context.DeadlineExceeded)
+
+	want := map[string]string{
+		EventReasonSetChanged:   "service-uid",
+		EventReasonExportFailed: "pod-uid",
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		events, err := kc.CoreV1().Events("voice").List(context.Background(), metav1.ListOptions{})
+		if err != nil {
+			t.Fatalf("failed to list events: %v", err)
+		}
+
+		found := make(map[string]string)
+		for _, ev := range events.Items {
+			found[ev.Reason] = string(ev.InvolvedObject.UID)
+		}
+
+		if len(found) == len(want) {
+			for reason, uid := range want {
+				if found[reason] != uid {
+					t.Errorf("expected %s event on UID %s, got %q", reason, uid, found[reason])
+				}
+			}
+
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d events, got %v", len(want), found)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestEventNotifierDoesNotBlock(t *testing.T) {
+	kc := fake.NewSimpleClientset()
+
+	release := make(chan struct{})
+	defer close(release)
+
+	var mu sync.Mutex
+	var lookups int
+
+	// Lookups of the Pod hang until released, and then fail.
+	kc.PrependReactor("get", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		mu.Lock()
+		lookups++
+		mu.Unlock()
+
+		<-release
+
+		return true, nil, apierrors.NewNotFound(v1.Resource("pods"), "kamailio-0")
+	})
+
+	n, err := NewEventNotifier(kc, &v1.ObjectReference{APIVersion: "v1", Kind: "Pod", Namespace: "voice", Name: "kamailio-0"}, nil)
+	if err != nil {
+		t.Fatalf("failed to create notifier: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 5; i++ {
+			n.SetChanged(&sets.State{ID: 1})
+			n.Exported(time.Millisecond, context.DeadlineExceeded)
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the notifier blocked on the lookup of the Pod")
+	}
+
+	release <- struct{}{}
+
+	n.Close()
+
+	// The failed lookup is remembered, so the remaining events do not look the Pod up again.
+	mu.Lock()
+	defer mu.Unlock()
+
+	if lookups != 1 {
+		t.Errorf("expected 1 lookup of the Pod, got %d", lookups)
+	}
+}
@@ -0,0 +1,149 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CyCoreSystems/dispatchers/v2/exporter"
+	"github.com/CyCoreSystems/dispatchers/v2/sets"
+)
+
+// stderrGrace is the amount of time to wait, once a command has exited, for the remainder of its standard error.
+var stderrGrace = 100 * time.Millisecond
+
+// ExecNotifier is a dispatchers.Notifier which runs a command on each change of the dispatcher sets.
+// The command receives a JSON exporter.Snapshot of the dispatcher sets on its standard input, and the following environment variables in addition to those of the daemon:
+//
+//  * `DISPATCHERS_REVISION` is the revision of the snapshot:  that of the Revision function, such as the revision of the Controller, or otherwise the number of the notification.
+//
+//  * `DISPATCHERS_SETS` is a comma-separated list of the IDs of the dispatcher sets.
+//
+// The notification fails if the command exits with a non-zero status or does not complete within the timeout.
+type ExecNotifier struct {
+
+	// Command is the path of the command to run.
+	Command string
+
+	// Args is the list of arguments to pass to the command.
+	Args []string
+
+	// Timeout is the amount of time to wait for the command to complete before it is killed.  If not specified, DefaultTimeout is used.
+	Timeout time.Duration
+
+	// Revision returns the revision of the dispatcher sets, such as Controller.Revision.  If not specified, the notifier numbers its own notifications.
+	Revision func() uint64
+
+	revision uint64
+
+	mu sync.Mutex
+}
+
+// Notify implements dispatchers.Notifier
+func (n *ExecNotifier) Notify(sets []*sets.State) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	timeout := n.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+
+	n.revision++
+
+	revision := n.revision
+	if n.Revision != nil {
+		revision = n.Revision()
+	}
+
+	input, err := json.Marshal(exporter.NewSnapshot(revision, "", sets))
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+
+	var ids []string
+	for _, s := range sets {
+		ids = append(ids, strconv.Itoa(s.ID))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// The standard input and error of the command are pipes of our own, rather than ones managed by exec.Cmd, so that a descendant of the command which keeps them open cannot delay the completion of the notification.
+	stdinReader, stdinWriter, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to create pipe: %w", err)
+	}
+	defer stdinWriter.Close()
+
+	stderrReader, stderrWriter, err := os.Pipe()
+	if err != nil {
+		stdinReader.Close()
+		return fmt.Errorf("failed to create pipe: %w", err)
+	}
+	defer stderrReader.Close()
+
+	cmd := exec.CommandContext(ctx, n.Command, n.Args...)
+	cmd.Stdin = stdinReader
+	cmd.Stderr = stderrWriter
+	cmd.Env = append(os.Environ(),
+		"DISPATCHERS_REVISION="+strconv.FormatUint(revision, 10),
+		"DISPATCHERS_SETS="+strings.Join(ids, ","),
+	)
+
+	err = cmd.Start()
+
+	// The command holds its own copies of these ends of the pipes.
+	stdinReader.Close()
+	stderrWriter.Close()
+
+	if err != nil {
+		return fmt.Errorf("failed to run command %s: %w", n.Command, err)
+	}
+
+	go func() {
+		stdinWriter.Write(input) // nolint: errcheck
+		stdinWriter.Close()
+	}()
+
+	stderr := make(chan []byte, 1)
+
+	go func() {
+		data, _ := ioutil.ReadAll(stderrReader) // nolint: errcheck
+		stderr <- data
+	}()
+
+	err = cmd.Wait()
+
+	// Abandon any input the command did not read, and collect what it wrote without waiting for any descendants which still hold the pipe open.
+	stdinWriter.Close()
+
+	if stderrReader.SetReadDeadline(time.Now().Add(stderrGrace)) != nil {
+		stderrReader.Close()
+	}
+
+	output := <-stderr
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("command %s timed out after %s", n.Command, timeout)
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return fmt.Errorf("command %s exited with status %d: %s", n.Command, exitErr.ExitCode(), strings.TrimSpace(string(output)))
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to run command %s: %w", n.Command, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package notifier
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CyCoreSystems/dispatchers/v2/sets"
+)
+
+func TestExecNotifier(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+
+	n := &ExecNotifier{
+		Command:  "/bin/sh",
+		Args:     []string{"-c", `echo "$DISPATCHERS_REVISION $DISPATCHERS_SETS $1" > "$2"; cat >> "$2"`, "sh", "an argument", out},
+		Revision: func() uint64 { return 42 },
+	}
+
+	err := n.Notify([]*sets.State{
+		{ID: 1, Endpoints: []*sets.Endpoint{{Address: "10.0.0.1", Port: 5060}}},
+		{ID: 2},
+	})
+	if err != nil {
+		t.Fatalf("notification failed: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatalf("failed to read output: %v", err)
+	}
+
+	lines := strings.SplitN(string(data), "\n", 2)
+
+	if lines[0] != "42 1,2 an argument" {
+		t.Errorf("unexpected environment and arguments: %q", lines[0])
+	}
+
+	if !strings.Contains(lines[1], `"revision":42`) || !strings.Contains(lines[1], `"address":"10.0.0.1"`) {
+		t.Errorf("unexpected snapshot on standard input: %s", lines[1])
+	}
+}
+
+func TestExecNotifierFailure(t *testing.T) {
+	n := &ExecNotifier{
+		Command: "/bin/sh",
+		Args:    []string{"-c", "echo reload failed >&2; exit 3"},
+	}
+
+	err := n.Notify(nil)
+	if err == nil || !strings.Contains(err.Error(), "status 3") || !strings.Contains(err.Error(), "reload failed") {
+		t.Fatalf("expected the exit status and error output, got %v", err)
+	}
+}
+
+func TestExecNotifierTimeout(t *testing.T) {
+	n := &ExecNotifier{
+		Command: "/bin/sh",
+		Args:    []string{"-c", "exec sleep 5"},
+		Timeout: 100 * time.Millisecond,
+	}
+
+	started := time.Now()
+
+	err := n.Notify(nil)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Errorf("notification took %s to time out", elapsed)
+	}
+}
+
+func TestExecNotifierDescendantHoldsPipes(t *testing.T) {
+	n := &ExecNotifier{
+		Command: "/bin/sh",
+		Args:    []string{"-c", "sleep 5 & echo started >&2"},
+		Timeout: time.Second,
+	}
+
+	started := time.Now()
+
+	if err := n.Notify(nil); err != nil {
+		t.Fatalf("notification failed: %v", err)
+	}
+
+	if elapsed := time.Since(started); elapsed > 900*time.Millisecond {
+		t.Errorf("notification waited %s for a descendant of the command", elapsed)
+	}
+}
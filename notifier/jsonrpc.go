@@ -0,0 +1,176 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultTimeout is the amount of time to wait for a response to an RPC request, if no other timeout is specified.
+const DefaultTimeout = 5 * time.Second
+
+var jsonrpcID int64
+
+type jsonrpcRequest struct {
+	Version string        `json:"jsonrpc"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params,omitempty"`
+	ID      int64         `json:"id"`
+}
+
+type jsonrpcResponse struct {
+	Version string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *jsonrpcError   `json:"error,omitempty"`
+	ID      int64           `json:"id"`
+}
+
+type jsonrpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *jsonrpcError) Error() string {
+	return fmt.Sprintf("%s (%d)", e.Message, e.Code)
+}
+
+func newJSONRPCRequest(method string, params ...interface{}) ([]byte, error) {
+	return json.Marshal(&jsonrpcRequest{
+		Version: "2.0",
+		Method:  method,
+		Params:  params,
+		ID:      atomic.AddInt64(&jsonrpcID, 1),
+	})
+}
+
+func parseJSONRPCResponse(method string, data []byte) (json.RawMessage, error) {
+	resp := new(jsonrpcResponse)
+
+	if err := json.Unmarshal(data, resp); err != nil {
+		return nil, fmt.Errorf("failed to parse response to %s: %w", method, err)
+	}
+
+	if resp.Error != nil {
+		return nil, fmt.Errorf("%s failed: %w", method, resp.Error)
+	}
+
+	return resp.Result, nil
+}
+
+// invokeJSONRPCHTTP calls a JSON-RPC method over HTTP, returning its result.
+func invokeJSONRPCHTTP(url string, timeout time.Duration, method string, params ...interface{}) (json.RawMessage, error) {
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+
+	req, err := newJSONRPCRequest(method, params...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request for %s: %w", method, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(req))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request for %s: %w", method, err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call %s: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response to %s: %w", method, err)
+	}
+
+	if resp.StatusCode != http.StatusOK && len(data) == 0 {
+		return nil, fmt.Errorf("%s failed: %s", method, resp.Status)
+	}
+
+	return parseJSONRPCResponse(method, data)
+}
+
+// invokeJSONRPCDatagram calls a JSON-RPC method over a datagram socket ("udp" or "unixgram"), returning its result.
+func invokeJSONRPCDatagram(network, addr string, timeout time.Duration, method string, params ...interface{}) (json.RawMessage, error) {
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+
+	req, err := newJSONRPCRequest(method, params...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request for %s: %w", method, err)
+	}
+
+	var conn net.Conn
+
+	if network == "unixgram" {
+		// A unix datagram reply requires that the client be bound to its own address.
+		conn, err = dialUnixgram(addr)
+	} else {
+		conn, err = net.DialTimeout(network, addr, timeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	defer conn.Close()
+
+	if err = conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, fmt.Errorf("failed to set deadline: %w", err)
+	}
+
+	if _, err = conn.Write(req); err != nil {
+		return nil, fmt.Errorf("failed to send %s: %w", method, err)
+	}
+
+	buf := make([]byte, 65535)
+
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response to %s: %w", method, err)
+	}
+
+	return parseJSONRPCResponse(method, buf[:n])
+}
+
+func dialUnixgram(addr string) (net.Conn, error) {
+	f, err := ioutil.TempFile("", "dispatchers-rpc-*.sock")
+	if err != nil {
+		return nil, err
+	}
+
+	local := f.Name()
+	f.Close()
+	os.Remove(local)
+
+	conn, err := net.DialUnix("unixgram", &net.UnixAddr{Name: local, Net: "unixgram"}, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+
+	return &unixgramConn{UnixConn: conn, local: local}, nil
+}
+
+// unixgramConn removes its bound local socket file when it is closed.
+type unixgramConn struct {
+	*net.UnixConn
+
+	local string
+}
+
+func (c *unixgramConn) Close() error {
+	defer os.Remove(c.local)
+
+	return c.UnixConn.Close()
+}
@@ -0,0 +1,32 @@
+package notifier
+
+import (
+	"time"
+
+	"github.com/CyCoreSystems/dispatchers/v2/sets"
+)
+
+// JSONRPCNotifier is a dispatchers.Notifier which tells Kamailio to reload its dispatcher module (or another module, using Method) using the JSON-RPC interface of its jsonrpcs module over HTTP.
+// Unlike the BinRPCNotifier, it waits for Kamailio to acknowledge the reload, so a successful notification means that Kamailio has actually reloaded.
+type JSONRPCNotifier struct {
+
+	// URL is the address of the kamailio JSON-RPC HTTP interface, such as "http://127.0.0.1:5060/RPC".
+	URL string
+
+	// Method is the RPC method to invoke.  If not specified, DispatcherReload is used.
+	Method string
+
+	// Timeout is the amount of time to wait for kamailio to respond.  If not specified, DefaultTimeout is used.
+	Timeout time.Duration
+}
+
+// Notify implements dispatchers.Notifier
+func (n *JSONRPCNotifier) Notify(sets []*sets.State) error {
+	method := n.Method
+	if method == "" {
+		method = DispatcherReload
+	}
+
+	_, err := invokeJSONRPCHTTP(n.URL, n.Timeout, method)
+	return err
+}
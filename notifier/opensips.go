@@ -0,0 +1,69 @@
+package notifier
+
+import (
+	"time"
+
+	"github.com/CyCoreSystems/dispatchers/v2/sets"
+)
+
+const (
+	// OpenSIPSDispatcherReload is the OpenSIPS MI command which reloads the dispatcher module.
+	OpenSIPSDispatcherReload = "ds_reload"
+
+	// OpenSIPSLoadBalancerReload is the OpenSIPS MI command which reloads the load_balancer module.
+	OpenSIPSLoadBalancerReload = "lb_reload"
+)
+
+// MIHTTPNotifier is a dispatchers.Notifier which tells OpenSIPS to reload a module using the MI JSON-RPC interface of its mi_http module.
+type MIHTTPNotifier struct {
+
+	// URL is the address of the mi_http interface, such as "http://127.0.0.1:8888/mi".
+	URL string
+
+	// Command is the MI command to invoke.  If not specified, OpenSIPSDispatcherReload is used.
+	Command string
+
+	// Timeout is the amount of time to wait for OpenSIPS to respond.  If not specified, DefaultTimeout is used.
+	Timeout time.Duration
+}
+
+// Notify implements dispatchers.Notifier
+func (n *MIHTTPNotifier) Notify(sets []*sets.State) error {
+	_, err := invokeJSONRPCHTTP(n.URL, n.Timeout, openSIPSCommand(n.Command))
+	return err
+}
+
+// MIDatagramNotifier is a dispatchers.Notifier which tells OpenSIPS to reload a module using the MI JSON-RPC interface of its mi_datagram module.
+type MIDatagramNotifier struct {
+
+	// Network is the type of the datagram socket:  "udp" or "unixgram".  If not specified, "udp" is used.
+	Network string
+
+	// Address is the address of the mi_datagram socket, such as "127.0.0.1:8080" or "/tmp/opensips.sock".
+	Address string
+
+	// Command is the MI command to invoke.  If not specified, OpenSIPSDispatcherReload is used.
+	Command string
+
+	// Timeout is the amount of time to wait for OpenSIPS to respond.  If not specified, DefaultTimeout is used.
+	Timeout time.Duration
+}
+
+// Notify implements dispatchers.Notifier
+func (n *MIDatagramNotifier) Notify(sets []*sets.State) error {
+	network := n.Network
+	if network == "" {
+		network = "udp"
+	}
+
+	_, err := invokeJSONRPCDatagram(network, n.Address, n.Timeout, openSIPSCommand(n.Command))
+	return err
+}
+
+func openSIPSCommand(cmd string) string {
+	if cmd == "" {
+		return OpenSIPSDispatcherReload
+	}
+
+	return cmd
+}
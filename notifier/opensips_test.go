@@ -0,0 +1,130 @@
+package notifier
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// miResponse returns the response of an OpenSIPS MI stub to a JSON-RPC request:  an error for the "fail" command and "OK" otherwise.
+func miResponse(t *testing.T, data []byte, commands chan<- string) []byte {
+	t.Helper()
+
+	req := new(jsonrpcRequest)
+	if err := json.Unmarshal(data, req); err != nil {
+		t.Errorf("failed to decode MI request %q: %v", data, err)
+	}
+
+	commands <- req.Method
+
+	resp := &jsonrpcResponse{Version: "2.0", ID: req.ID, Result: json.RawMessage(`"OK"`)}
+
+	if req.Method == "fail" {
+		resp = &jsonrpcResponse{Version: "2.0", ID: req.ID, Error: &jsonrpcError{Code: 500, Message: "Module not loaded"}}
+	}
+
+	out, _ := json.Marshal(resp) // nolint: errcheck
+
+	return out
+}
+
+func TestMIHTTPNotifier(t *testing.T) {
+	commands := make(chan string, 1)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("expected a JSON request, got %q", ct)
+		}
+
+		var body json.RawMessage
+		json.NewDecoder(r.Body).Decode(&body) // nolint: errcheck
+
+		w.Write(miResponse(t, body, commands)) // nolint: errcheck
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		command string
+		want    string
+		fail    bool
+	}{
+		{"", OpenSIPSDispatcherReload, false},
+		{OpenSIPSLoadBalancerReload, OpenSIPSLoadBalancerReload, false},
+		{"fail", "fail", true},
+	}
+
+	for _, tt := range tests {
+		err := (&MIHTTPNotifier{URL: srv.URL, Command: tt.command}).Notify(nil)
+		if tt.fail && (err == nil || !strings.Contains(err.Error(), "Module not loaded")) {
+			t.Errorf("%q: expected the MI error to be reported, got %v", tt.command, err)
+		}
+		if !tt.fail && err != nil {
+			t.Errorf("%q: notification failed: %v", tt.command, err)
+		}
+
+		if got := <-commands; got != tt.want {
+			t.Errorf("%q: expected the %s command, got %s", tt.command, tt.want, got)
+		}
+	}
+
+	srv.Close()
+
+	if err := (&MIHTTPNotifier{URL: srv.URL}).Notify(nil); err == nil {
+		t.Error("expected the notification of a stopped OpenSIPS to fail")
+	}
+}
+
+func TestMIDatagramNotifier(t *testing.T) {
+	tests := []struct {
+		network string
+		addr    string
+	}{
+		{"udp", "127.0.0.1:0"},
+		{"unixgram", filepath.Join(t.TempDir(), "opensips.sock")},
+	}
+
+	for _, tt := range tests {
+		conn, err := net.ListenPacket(tt.network, tt.addr)
+		if err != nil {
+			t.Fatalf("%s: failed to listen: %v", tt.network, err)
+		}
+		defer conn.Close()
+
+		commands := make(chan string, 1)
+
+		go func() {
+			buf := make([]byte, 65535)
+
+			for {
+				n, from, err := conn.ReadFrom(buf)
+				if err != nil {
+					return
+				}
+
+				conn.WriteTo(miResponse(t, buf[:n], commands), from) // nolint: errcheck
+			}
+		}()
+
+		n := &MIDatagramNotifier{Network: tt.network, Address: conn.LocalAddr().String(), Command: OpenSIPSLoadBalancerReload}
+
+		if err = n.Notify(nil); err != nil {
+			t.Errorf("%s: notification failed: %v", tt.network, err)
+		}
+
+		if got := <-commands; got != OpenSIPSLoadBalancerReload {
+			t.Errorf("%s: expected the %s command, got %s", tt.network, OpenSIPSLoadBalancerReload, got)
+		}
+
+		n.Command = "fail"
+
+		if err = n.Notify(nil); err == nil || !strings.Contains(err.Error(), "Module not loaded") {
+			t.Errorf("%s: expected the MI error to be reported, got %v", tt.network, err)
+		}
+
+		<-commands
+	}
+}
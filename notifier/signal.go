@@ -0,0 +1,122 @@
+package notifier
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/CyCoreSystems/dispatchers/v2/sets"
+)
+
+// SignalNotifier is a dispatchers.Notifier which sends a signal to a process on each change of the dispatcher sets, such as to tell it to reload its configuration.
+// The process is found by its PID file or, when the daemon shares a PID namespace with it (such as with `shareProcessNamespace` in a Kubernetes Pod), by its name.
+type SignalNotifier struct {
+
+	// PIDFile is the path of a file containing the PID of the process.
+	PIDFile string
+
+	// ProcessName is the name of the process, used if PIDFile is not specified.  Every process with this name is signaled.
+	ProcessName string
+
+	// Signal is the signal to send.  If not specified, SIGHUP is used.
+	Signal os.Signal
+}
+
+// Notify implements dispatchers.Notifier
+func (n *SignalNotifier) Notify(sets []*sets.State) error {
+	sig := n.Signal
+	if sig == nil {
+		sig = syscall.SIGHUP
+	}
+
+	pids, err := n.findProcesses()
+	if err != nil {
+		return err
+	}
+
+	for _, pid := range pids {
+		p, err := os.FindProcess(pid)
+		if err != nil {
+			return fmt.Errorf("failed to find process %d: %w", pid, err)
+		}
+
+		if err = p.Signal(sig); err != nil {
+			return fmt.Errorf("failed to signal process %d: %w", pid, err)
+		}
+	}
+
+	return nil
+}
+
+func (n *SignalNotifier) findProcesses() ([]int, error) {
+	if n.PIDFile != "" {
+		data, err := ioutil.ReadFile(n.PIDFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read PID file: %w", err)
+		}
+
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse PID file %s: %w", n.PIDFile, err)
+		}
+
+		return []int{pid}, nil
+	}
+
+	if n.ProcessName == "" {
+		return nil, fmt.Errorf("neither PID file nor process name is specified")
+	}
+
+	pids, err := findProcessesByName(n.ProcessName)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pids) == 0 {
+		return nil, fmt.Errorf("no process named %s found", n.ProcessName)
+	}
+
+	return pids, nil
+}
+
+// findProcessesByName searches procfs for processes whose command name or executable name is the given name.
+func findProcessesByName(name string) (pids []int, err error) {
+	dirs, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list processes: %w", err)
+	}
+
+	self := os.Getpid()
+
+	for _, d := range dirs {
+		pid, err := strconv.Atoi(d.Name())
+		if err != nil || pid == self {
+			continue
+		}
+
+		if comm, err := ioutil.ReadFile(filepath.Join("/proc", d.Name(), "comm")); err == nil {
+			if strings.TrimSpace(string(comm)) == name {
+				pids = append(pids, pid)
+				continue
+			}
+		}
+
+		if cmdline, err := ioutil.ReadFile(filepath.Join("/proc", d.Name(), "cmdline")); err == nil {
+			argv0 := cmdline
+			if i := bytes.IndexByte(cmdline, 0); i >= 0 {
+				argv0 = cmdline[:i]
+			}
+
+			if filepath.Base(string(argv0)) == name {
+				pids = append(pids, pid)
+			}
+		}
+	}
+
+	return pids, nil
+}
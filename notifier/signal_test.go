@@ -0,0 +1,135 @@
+package notifier
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// startSleep starts a child process which sleeps until it is signaled, running sleep under the given name.
+func startSleep(t *testing.T, name string) *exec.Cmd {
+	t.Helper()
+
+	sleep, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skipf("sleep is not available: %v", err)
+	}
+
+	// The process is named for the link through which it is run.
+	link := filepath.Join(t.TempDir(), name)
+	if err = os.Symlink(sleep, link); err != nil {
+		t.Fatalf("failed to link sleep: %v", err)
+	}
+
+	cmd := exec.Command(link, "60")
+	if err = cmd.Start(); err != nil {
+		t.Fatalf("failed to start sleep: %v", err)
+	}
+
+	t.Cleanup(func() {
+		cmd.Process.Kill() // nolint: errcheck
+		cmd.Wait()         // nolint: errcheck
+	})
+
+	return cmd
+}
+
+// waitSignaled waits for a child process to exit, returning the signal by which it was terminated.
+func waitSignaled(t *testing.T, cmd *exec.Cmd) syscall.Signal {
+	t.Helper()
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the process to exit")
+	}
+
+	status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		t.Fatalf("expected the process to be terminated by a signal, got %s", cmd.ProcessState)
+	}
+
+	return status.Signal()
+}
+
+func writePIDFile(t *testing.T, contents string) string {
+	t.Helper()
+
+	filename := filepath.Join(t.TempDir(), "proc.pid")
+
+	if err := ioutil.WriteFile(filename, []byte(contents), 0644); err != nil {
+		t.Fatalf("failed to write PID file: %v", err)
+	}
+
+	return filename
+}
+
+func TestSignalNotifierPIDFile(t *testing.T) {
+	cmd := startSleep(t, "sleep")
+
+	// PID files usually end with a newline.
+	n := &SignalNotifier{PIDFile: writePIDFile(t, strconv.Itoa(cmd.Process.Pid)+"\n")}
+
+	if err := n.Notify(nil); err != nil {
+		t.Fatalf("notification failed: %v", err)
+	}
+
+	if sig := waitSignaled(t, cmd); sig != syscall.SIGHUP {
+		t.Errorf("expected SIGHUP, got %s", sig)
+	}
+
+	// The process has exited, so it can no longer be signaled.
+	if err := n.Notify(nil); err == nil {
+		t.Error("expected the signal to a missing process to fail")
+	}
+}
+
+func TestSignalNotifierProcessName(t *testing.T) {
+	name := "dispatchers-test-" + strconv.Itoa(os.Getpid())
+
+	first := startSleep(t, name)
+	second := startSleep(t, name)
+
+	n := &SignalNotifier{ProcessName: name, Signal: syscall.SIGTERM}
+
+	if err := n.Notify(nil); err != nil {
+		t.Fatalf("notification failed: %v", err)
+	}
+
+	// Every process with the name is signaled.
+	for _, cmd := range []*exec.Cmd{first, second} {
+		if sig := waitSignaled(t, cmd); sig != syscall.SIGTERM {
+			t.Errorf("expected SIGTERM, got %s", sig)
+		}
+	}
+
+	if err := n.Notify(nil); err == nil {
+		t.Error("expected the signal to a missing process to fail")
+	}
+}
+
+func TestSignalNotifierErrors(t *testing.T) {
+	tests := map[string]*SignalNotifier{
+		"missing PID file": {PIDFile: filepath.Join(t.TempDir(), "missing.pid")},
+		"invalid PID file": {PIDFile: writePIDFile(t, "kamailio\n")},
+		"empty PID file":   {PIDFile: writePIDFile(t, "")},
+		"no process":       {},
+	}
+
+	for name, n := range tests {
+		if err := n.Notify(nil); err == nil {
+			t.Errorf("%s: expected the notification to fail", name)
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CyCoreSystems/dispatchers/v2/exporter"
+	"github.com/CyCoreSystems/dispatchers/v2/sets"
+)
+
+const (
+	// WebhookSignatureHeader is the HTTP header which carries the HMAC-SHA256 signature of a webhook body, in the form `sha256=<hex>`.
+	WebhookSignatureHeader = "X-Dispatchers-Signature"
+
+	// WebhookRevisionHeader is the HTTP header which carries the revision of a webhook body.
+	WebhookRevisionHeader = "X-Dispatchers-Revision"
+)
+
+// WebhookEndpoint describes a single recipient of webhook notifications.
+type WebhookEndpoint struct {
+
+	// URL is the address to which notifications are POSTed.
+	URL string
+
+	// Secret is the key with which the body of each notification is signed.  If not specified, notifications are not signed.
+	Secret string
+
+	// Timeout is the amount of time to wait for each attempt to complete.  If not specified, DefaultTimeout is used.
+	Timeout time.Duration
+}
+
+// WebhookDiff describes the changes to the dispatcher sets since the previous notification.
+type WebhookDiff struct {
+	// Revision is the revision of the dispatcher sets, such as that of the Controller.
+	Revision uint64 `json:"revision"`
+
+	// Timestamp is the time at which the notification was generated.
+	Timestamp time.Time `json:"timestamp"`
+
+	// Changes is the list of dispatcher sets which have changed.
+	Changes []*WebhookSetChange `json:"changes"`
+}
+
+// WebhookSetChange describes the changes to the membership of a single dispatcher set.
+type WebhookSetChange struct {
+	ID      int                          `json:"id"`
+	Added   []*exporter.SnapshotEndpoint `json:"added"`
+	Removed []*exporter.SnapshotEndpoint `json:"removed"`
+
+	// Updated are the members whose readiness, drain, or attributes have changed.
+	Updated []*exporter.SnapshotEndpoint `json:"updated"`
+}
+
+// WebhookNotifier is a dispatchers.Notifier which POSTs the state of the dispatcher sets as JSON to a set of HTTP endpoints.
+// By default, the body is a complete exporter.Snapshot of the dispatcher sets;  if Diff is set, it is instead a WebhookDiff of the changes since the previous notification.
+// Each endpoint is notified concurrently, and failed attempts are retried with exponential backoff.
+type WebhookNotifier struct {
+
+	// Endpoints is the list of recipients of notifications.
+	Endpoints []*WebhookEndpoint
+
+	// Diff indicates that only the changes since the previous notification should be sent.
+	// The changes are tracked for each endpoint, since its last successful notification, so changes which could not be delivered to an endpoint are included in its next notification.
+	Diff bool
+
+	// Retries is the number of times a failed notification is retried.  If not specified, 3 is used.  Set it to a negative value to disable retries.
+	Retries int
+
+	// Backoff is the delay before the first retry, which is doubled for each subsequent retry.  If not specified, one second is used.
+	Backoff time.Duration
+
+	// Revision returns the revision of the dispatcher sets, such as Controller.Revision.  If not specified, the notifier numbers its own notifications.
+	Revision func() uint64
+
+	// sequence numbers the notifications.
+	sequence uint64
+
+	// previous holds, for each endpoint, the members of the dispatcher sets as of its last successful notification.
+	previous map[*WebhookEndpoint]map[int][]*sets.Endpoint
+
+	// delivered holds, for each endpoint, the sequence number of the notification from which previous was taken.
+	delivered map[*WebhookEndpoint]uint64
+
+	// mu protects the state of the notifier, but is not held while the notifications are delivered.
+	mu sync.Mutex
+}
+
+// Notify implements dispatchers.Notifier
+func (n *WebhookNotifier) Notify(states []*sets.State) error {
+	current := currentMembers(states)
+
+	n.mu.Lock()
+
+	n.sequence++
+
+	sequence := n.sequence
+
+	revision := sequence
+	if n.Revision != nil {
+		revision = n.Revision()
+	}
+
+	bodies := make([][]byte, len(n.Endpoints))
+
+	for i, ep := range n.Endpoints {
+		body, err := n.body(ep, revision, states, current)
+		if err != nil {
+			n.mu.Unlock()
+			return fmt.Errorf("failed to encode webhook body: %w", err)
+		}
+
+		bodies[i] = body
+	}
+
+	n.mu.Unlock()
+
+	// The lock is not held during the delivery, so that retries of a slow endpoint do not hold up other notifications.
+	errs := make([]error, len(n.Endpoints))
+
+	var wg sync.WaitGroup
+
+	for i, ep := range n.Endpoints {
+		wg.Add(1)
+
+		go func(i int, ep *WebhookEndpoint) {
+			defer wg.Done()
+
+			errs[i] = n.deliver(ep, revision, bodies[i])
+		}(i, ep)
+	}
+
+	wg.Wait()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.previous == nil {
+		n.previous = make(map[*WebhookEndpoint]map[int][]*sets.Endpoint)
+		n.delivered = make(map[*WebhookEndpoint]uint64)
+	}
+
+	var failures []string
+	for i, err := range errs {
+		if err != nil {
+			failures = append(failures, err.Error())
+			continue
+		}
+
+		ep := n.Endpoints[i]
+
+		// Only a successful notification advances the state from which the next diff of the endpoint is made, and never to that of an older notification which completed later.
+		if sequence > n.delivered[ep] {
+			n.previous[ep] = current
+			n.delivered[ep] = sequence
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("%d of %d webhooks failed: %s", len(failures), len(n.Endpoints), strings.Join(failures, "; "))
+	}
+
+	return nil
+}
+
+func currentMembers(states []*sets.State) map[int][]*sets.Endpoint {
+	current := make(map[int][]*sets.Endpoint, len(states))
+
+	for _, s := range states {
+		current[s.ID] = s.Endpoints
+	}
+
+	return current
+}
+
+// body returns the body of the notification of the given endpoint.
+func (n *WebhookNotifier) body(ep *WebhookEndpoint, revision uint64, states []*sets.State, current map[int][]*sets.Endpoint) ([]byte, error) {
+	if !n.Diff {
+		return json.Marshal(exporter.NewSnapshot(revision, "", states))
+	}
+
+	diff := &WebhookDiff{
+		Revision:  revision,
+		Timestamp: time.Now().UTC(),
+		Changes:   []*WebhookSetChange{},
+	}
+
+	previous := n.previous[ep]
+
+	for _, s := range states {
+		added, removed := sets.Diff(previous[s.ID], s.Endpoints)
+		updated := updatedMembers(previous[s.ID], s.Endpoints)
+
+		if len(added) == 0 && len(removed) == 0 && len(updated) == 0 {
+			continue
+		}
+
+		diff.Changes = append(diff.Changes, &WebhookSetChange{
+			ID:      s.ID,
+			Added:   snapshotEndpoints(added),
+			Removed: snapshotEndpoints(removed),
+			Updated: snapshotEndpoints(updated),
+		})
+	}
+
+	// Sets which no longer exist have lost all of their members.
+	var departed []int
+
+	for id, members := range previous {
+		if _, ok := current[id]; !ok && len(members) > 0 {
+			departed = append(departed, id)
+		}
+	}
+
+	sort.Ints(departed)
+
+	for _, id := range departed {
+		diff.Changes = append(diff.Changes, &WebhookSetChange{
+			ID:      id,
+			Added:   snapshotEndpoints(nil),
+			Removed: snapshotEndpoints(previous[id]),
+			Updated: snapshotEndpoints(nil),
+		})
+	}
+
+	return json.Marshal(diff)
+}
+
+// updatedMembers returns the members of current which were also members of previous, but whose readiness, drain, or attributes differ.
+func updatedMembers(previous []*sets.Endpoint, current []*sets.Endpoint) (updated []*sets.Endpoint) {
+	prev := make(map[string]*sets.Endpoint, len(previous))
+	for _, ep := range previous {
+		prev[ep.String()] = ep
+	}
+
+	for _, ep := range current {
+		p, ok := prev[ep.String()]
+		if !ok {
+			continue
+		}
+
+		if p.NotReady != ep.NotReady || p.Drained != ep.Drained || !reflect.DeepEqual(p.Attributes, ep.Attributes) {
+			updated = append(updated, ep)
+		}
+	}
+
+	return updated
+}
+
+func snapshotEndpoints(list []*sets.Endpoint) []*exporter.SnapshotEndpoint {
+	out := []*exporter.SnapshotEndpoint{}
+
+	for _, ep := range list {
+		out = append(out, exporter.NewSnapshotEndpoint(ep))
+	}
+
+	return out
+}
+
+// deliver POSTs the body to the endpoint, retrying as necessary.
+func (n *WebhookNotifier) deliver(ep *WebhookEndpoint, revision uint64, body []byte) (err error) {
+	retries := n.Retries
+	if retries == 0 {
+		retries = 3
+	}
+
+	backoff := n.Backoff
+	if backoff == 0 {
+		backoff = time.Second
+	}
+
+	for attempt := 0; ; attempt++ {
+		if err = n.post(ep, revision, body); err == nil {
+			return nil
+		}
+
+		if attempt >= retries {
+			return fmt.Errorf("webhook %s failed after %d attempts: %w", ep.URL, attempt+1, err)
+		}
+
+		time.Sleep(backoff << uint(attempt))
+	}
+}
+
+func (n *WebhookNotifier) post(ep *WebhookEndpoint, revision uint64, body []byte) error {
+	timeout := ep.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookRevisionHeader, strconv.FormatUint(revision, 10))
+
+	if ep.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(ep.Secret))
+		mac.Write(body)
+
+		req.Header.Set(WebhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+
+	return nil
+}
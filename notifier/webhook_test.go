@@ -0,0 +1,286 @@
+package notifier
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CyCoreSystems/dispatchers/v2/exporter"
+	"github.com/CyCoreSystems/dispatchers/v2/sets"
+)
+
+// webhookRecorder is an HTTP endpoint which records the webhook diffs it receives, failing while fail is set.
+type webhookRecorder struct {
+	fail  bool
+	diffs []*WebhookDiff
+
+	mu sync.Mutex
+}
+
+func (r *webhookRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.fail {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	diff := new(WebhookDiff)
+	if err := json.NewDecoder(req.Body).Decode(diff); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	r.diffs = append(r.diffs, diff)
+}
+
+func (r *webhookRecorder) setFailing(fail bool) {
+	r.mu.Lock()
+	r.fail = fail
+	r.mu.Unlock()
+}
+
+func (r *webhookRecorder) last() *WebhookDiff {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.diffs) == 0 {
+		return nil
+	}
+
+	return r.diffs[len(r.diffs)-1]
+}
+
+func changeOf(diff *WebhookDiff, id int) *WebhookSetChange {
+	for _, c := range diff.Changes {
+		if c.ID == id {
+			return c
+		}
+	}
+
+	return nil
+}
+
+func TestWebhookNotifierDiff(t *testing.T) {
+	healthy := new(webhookRecorder)
+	flaky := new(webhookRecorder)
+
+	healthySrv := httptest.NewServer(healthy)
+	defer healthySrv.Close()
+
+	flakySrv := httptest.NewServer(flaky)
+	defer flakySrv.Close()
+
+	n := &WebhookNotifier{
+		Endpoints: []*WebhookEndpoint{{URL: healthySrv.URL}, {URL: flakySrv.URL}},
+		Diff:      true,
+		Retries:   -1,
+	}
+
+	a := &sets.Endpoint{Address: "10.0.0.1", Port: 5060}
+	b := &sets.Endpoint{Address: "10.0.0.2", Port: 5060}
+	c := &sets.Endpoint{Address: "10.0.1.1", Port: 5060}
+
+	if err := n.Notify([]*sets.State{{ID: 1, Endpoints: []*sets.Endpoint{a}}, {ID: 2, Endpoints: []*sets.Endpoint{c}}}); err != nil {
+		t.Fatalf("first notification failed: %v", err)
+	}
+
+	// While the flaky endpoint fails, set 1 gains a member and set 2 disappears.
+	flaky.setFailing(true)
+
+	if err := n.Notify([]*sets.State{{ID: 1, Endpoints: []*sets.Endpoint{a, b}}}); err == nil {
+		t.Fatal("expected the notification of the failing endpoint to fail")
+	}
+
+	diff := healthy.last()
+	if change := changeOf(diff, 1); change == nil || len(change.Added) != 1 || change.Added[0].Address != "10.0.0.2" {
+		t.Errorf("expected 10.0.0.2 to be added to set 1, got %+v", change)
+	}
+
+	if change := changeOf(diff, 2); change == nil || len(change.Removed) != 1 || change.Removed[0].Address != "10.0.1.1" {
+		t.Errorf("expected the members of the departed set 2 to be removed, got %+v", change)
+	}
+
+	// Once it recovers, the flaky endpoint receives the changes it missed.
+	flaky.setFailing(false)
+
+	if err := n.Notify([]*sets.State{{ID: 1, Endpoints: []*sets.Endpoint{a, b}}}); err != nil {
+		t.Fatalf("third notification failed: %v", err)
+	}
+
+	if diff := healthy.last(); len(diff.Changes) != 0 {
+		t.Errorf("expected no changes for the healthy endpoint, got %+v", diff.Changes)
+	}
+
+	diff = flaky.last()
+	if diff.Revision != 3 {
+		t.Errorf("expected revision 3, got %d", diff.Revision)
+	}
+
+	if change := changeOf(diff, 1); change == nil || len(change.Added) != 1 || change.Added[0].Address != "10.0.0.2" {
+		t.Errorf("expected the missed addition to set 1 to be delivered, got %+v", change)
+	}
+
+	if change := changeOf(diff, 2); change == nil || len(change.Removed) != 1 {
+		t.Errorf("expected the missed removal of set 2 to be delivered, got %+v", change)
+	}
+}
+
+func TestWebhookNotifierSignature(t *testing.T) {
+	var signature, revision string
+	var body []byte
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature = r.Header.Get(WebhookSignatureHeader)
+		revision = r.Header.Get(WebhookRevisionHeader)
+		body, _ = ioutil.ReadAll(r.Body) // nolint: errcheck
+	}))
+	defer srv.Close()
+
+	n := &WebhookNotifier{
+		Endpoints: []*WebhookEndpoint{{URL: srv.URL, Secret: "s3cret"}},
+	}
+
+	if err := n.Notify([]*sets.State{{ID: 1}}); err != nil {
+		t.Fatalf("notification failed: %v", err)
+	}
+
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(body)
+
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); signature != want {
+		t.Errorf("expected signature %s, got %s", want, signature)
+	}
+
+	if revision != "1" {
+		t.Errorf("expected revision 1, got %s", revision)
+	}
+}
+
+func TestWebhookNotifierDrained(t *testing.T) {
+	var bodies [][]byte
+	var mu sync.Mutex
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body) // nolint: errcheck
+
+		mu.Lock()
+		bodies = append(bodies, body)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	snapshots := &WebhookNotifier{Endpoints: []*WebhookEndpoint{{URL: srv.URL}}}
+	diffs := &WebhookNotifier{Endpoints: []*WebhookEndpoint{{URL: srv.URL}}, Diff: true}
+
+	active := []*sets.State{{ID: 1, Endpoints: []*sets.Endpoint{{Address: "10.0.0.1", Port: 5060}, {Address: "10.0.0.2", Port: 5060}}}}
+	drained := []*sets.State{{ID: 1, Endpoints: []*sets.Endpoint{{Address: "10.0.0.1", Port: 5060, Drained: true}, {Address: "10.0.0.2", Port: 5060, NotReady: true}}}}
+
+	for _, n := range []*WebhookNotifier{snapshots, diffs} {
+		for _, states := range [][]*sets.State{active, drained} {
+			if err := n.Notify(states); err != nil {
+				t.Fatalf("notification failed: %v", err)
+			}
+		}
+	}
+
+	snapshot := new(exporter.Snapshot)
+	if err := json.Unmarshal(bodies[1], snapshot); err != nil {
+		t.Fatalf("failed to decode snapshot: %v", err)
+	}
+
+	if eps := snapshot.Sets[0].Endpoints; len(eps) != 2 || !eps[0].Drained || !eps[0].Ready || eps[1].Drained || eps[1].Ready {
+		t.Errorf("expected the snapshot to mark 10.0.0.1 as drained and 10.0.0.2 as not ready, got %+v, %+v", eps[0], eps[1])
+	}
+
+	diff := new(WebhookDiff)
+	if err := json.Unmarshal(bodies[3], diff); err != nil {
+		t.Fatalf("failed to decode diff: %v", err)
+	}
+
+	change := changeOf(diff, 1)
+	if change == nil || len(change.Added) != 0 || len(change.Removed) != 0 || len(change.Updated) != 2 {
+		t.Fatalf("expected both members of set 1 to be updated, got %+v", change)
+	}
+
+	if !change.Updated[0].Drained || change.Updated[1].Ready {
+		t.Errorf("expected the updates to carry the drain and readiness, got %+v, %+v", change.Updated[0], change.Updated[1])
+	}
+}
+
+func TestWebhookNotifierRevision(t *testing.T) {
+	revisions := make(chan string, 10)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		revisions <- r.Header.Get(WebhookRevisionHeader)
+	}))
+	defer srv.Close()
+
+	n := &WebhookNotifier{
+		Endpoints: []*WebhookEndpoint{{URL: srv.URL}},
+		Diff:      true,
+		Revision:  func() uint64 { return 42 },
+	}
+
+	if err := n.Notify([]*sets.State{{ID: 1}}); err != nil {
+		t.Fatalf("notification failed: %v", err)
+	}
+
+	if revision := <-revisions; revision != "42" {
+		t.Errorf("expected the revision of the Revision function, got %s", revision)
+	}
+}
+
+func TestWebhookNotifierBackoffDoesNotBlock(t *testing.T) {
+	revisions := make(chan string, 10)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		revisions <- r.Header.Get(WebhookRevisionHeader)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	n := &WebhookNotifier{
+		Endpoints: []*WebhookEndpoint{{URL: srv.URL}},
+		Retries:   1,
+		Backoff:   time.Second,
+	}
+
+	errs := make(chan error, 2)
+
+	go func() {
+		errs <- n.Notify([]*sets.State{{ID: 1}})
+	}()
+
+	if revision := <-revisions; revision != "1" {
+		t.Fatalf("expected the first attempt of notification 1, got %s", revision)
+	}
+
+	// While the first notification waits to retry, a second is delivered.
+	go func() {
+		errs <- n.Notify([]*sets.State{{ID: 1}})
+	}()
+
+	select {
+	case revision := <-revisions:
+		if revision != "2" {
+			t.Errorf("expected the first attempt of notification 2, got %s", revision)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("the second notification waited for the backoff of the first")
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err == nil {
+			t.Error("expected the notifications to fail")
+		}
+	}
+}
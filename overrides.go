@@ -0,0 +1,353 @@
+package dispatchers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CyCoreSystems/dispatchers/v2/sets"
+)
+
+// OverrideAction describes the manual change made to a dispatcher set by an Override.
+type OverrideAction string
+
+const (
+	// OverrideDisable removes a member from its dispatcher set.
+	OverrideDisable OverrideAction = "disable"
+
+	// OverrideDrain keeps a member in its dispatcher set, but marks it as drained, so that it receives no new traffic while its existing traffic completes.
+	OverrideDrain OverrideAction = "drain"
+
+	// OverrideAdd adds a (usually temporary) static member to a dispatcher set.
+	OverrideAdd OverrideAction = "add"
+)
+
+// Override is a manual change to the membership of a dispatcher set, which is layered on top of its discovered membership.
+type Override struct {
+	// Set is the ID of the dispatcher set.
+	Set int `json:"set"`
+
+	// Address is the address of the member.
+	Address string `json:"address"`
+
+	// Port is the port of the member.  For OverrideDisable and OverrideDrain, 0 matches the address on any port.
+	Port uint32 `json:"port,omitempty"`
+
+	// Action is the change made to the dispatcher set.
+	Action OverrideAction `json:"action"`
+
+	// Reason is an optional description of the reason for the override.
+	Reason string `json:"reason,omitempty"`
+
+	// Created is the time at which the override was made.
+	Created time.Time `json:"created"`
+
+	// Expires is the optional time at which the override is removed.
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// key identifies the member of the dispatcher set to which the override applies.
+func (o *Override) key() string {
+	return fmt.Sprintf("%d/%s/%d", o.Set, normalizeAddress(o.Address), o.Port)
+}
+
+func (o *Override) expired(now time.Time) bool {
+	return o.Expires != nil && !now.Before(*o.Expires)
+}
+
+func (o *Override) matches(ep *sets.Endpoint) bool {
+	return normalizeAddress(ep.Address) == normalizeAddress(o.Address) && (o.Port == 0 || o.Port == ep.Port)
+}
+
+func normalizeAddress(addr string) string {
+	if ip, ok := sets.ParseIP(addr); ok {
+		return ip.String()
+	}
+
+	return strings.ToLower(addr)
+}
+
+// Overrides holds the manual overrides of the membership of dispatcher sets.
+// There is at most one override for each member of a dispatcher set;  a new override of a member replaces any existing one.
+type Overrides struct {
+	// Filename is the optional file in which the overrides are persisted, so that they survive a restart.
+	Filename string
+
+	list map[string]*Override
+
+	mu sync.RWMutex
+}
+
+// Load reads the persisted overrides from the Filename, if it exists.
+func (o *Overrides) Load() error {
+	if o.Filename == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(o.Filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return fmt.Errorf("failed to read overrides from %s: %w", o.Filename, err)
+	}
+
+	var list []*Override
+
+	if err = json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("failed to parse overrides from %s: %w", o.Filename, err)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.list = make(map[string]*Override, len(list))
+
+	for _, ov := range list {
+		o.list[ov.key()] = ov
+	}
+
+	return nil
+}
+
+// List returns the current overrides, in order of set and address.
+func (o *Overrides) List() []*Override {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	return o.sorted()
+}
+
+// Set adds or replaces the override of a member of a dispatcher set and persists the overrides.
+// If the overrides cannot be persisted, the override is not made.
+func (o *Overrides) Set(ov *Override) error {
+	switch ov.Action {
+	case OverrideDisable, OverrideDrain:
+	case OverrideAdd:
+		if ov.Port == 0 {
+			return fmt.Errorf("a port is required to add a member")
+		}
+	default:
+		return fmt.Errorf("unhandled override action %q", ov.Action)
+	}
+
+	if ov.Address == "" {
+		return fmt.Errorf("an address is required")
+	}
+
+	if ov.Created.IsZero() {
+		ov.Created = time.Now().UTC()
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.list == nil {
+		o.list = make(map[string]*Override)
+	}
+
+	key := ov.key()
+	previous, existed := o.list[key]
+
+	o.list[key] = ov
+
+	if err := o.save(); err != nil {
+		if existed {
+			o.list[key] = previous
+		} else {
+			delete(o.list, key)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// Remove removes the override of a member of a dispatcher set, if there is one, and persists the overrides.
+func (o *Overrides) Remove(set int, address string, port uint32) (bool, error) {
+	key := (&Override{Set: set, Address: address, Port: port}).key()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	previous, ok := o.list[key]
+	if !ok {
+		return false, nil
+	}
+
+	delete(o.list, key)
+
+	if err := o.save(); err != nil {
+		o.list[key] = previous
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Expire removes the overrides which have expired, returning the IDs of the dispatcher sets they affected.
+// The expired overrides are removed even if the overrides cannot be persisted.
+func (o *Overrides) Expire(now time.Time) (changed []int, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	seen := make(map[int]bool)
+
+	for key, ov := range o.list {
+		if !ov.expired(now) {
+			continue
+		}
+
+		delete(o.list, key)
+
+		if !seen[ov.Set] {
+			seen[ov.Set] = true
+			changed = append(changed, ov.Set)
+		}
+	}
+
+	if len(changed) == 0 {
+		return nil, nil
+	}
+
+	sort.Ints(changed)
+
+	return changed, o.save()
+}
+
+// Apply layers the overrides on top of the given state of a dispatcher set, which is modified in place.
+// If include is not nil, only added members for which it returns true are added.
+func (o *Overrides) Apply(state *sets.State, include func(*sets.Endpoint) bool) *sets.State {
+	if o == nil || state == nil {
+		return state
+	}
+
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	now := time.Now()
+
+	var active []*Override
+
+	for _, ov := range o.sorted() {
+		if ov.Set == state.ID && !ov.expired(now) {
+			active = append(active, ov)
+		}
+	}
+
+	if len(active) == 0 {
+		return state
+	}
+
+	endpoints := make([]*sets.Endpoint, 0, len(state.Endpoints))
+
+	for _, ep := range state.Endpoints {
+		var action OverrideAction
+
+		for _, ov := range active {
+			if ov.Action != OverrideAdd && ov.matches(ep) {
+				action = ov.Action
+			}
+		}
+
+		switch action {
+		case OverrideDisable:
+			continue
+		case OverrideDrain:
+			ep.Drained = true
+		}
+
+		endpoints = append(endpoints, ep)
+	}
+
+	for _, ov := range active {
+		if ov.Action != OverrideAdd {
+			continue
+		}
+
+		ep := &sets.Endpoint{
+			Address: ov.Address,
+			Port:    ov.Port,
+			Attributes: map[string]string{
+				"override": string(OverrideAdd),
+			},
+		}
+
+		if include != nil && !include(ep) {
+			continue
+		}
+
+		var exists bool
+
+		for _, existing := range endpoints {
+			if ov.matches(existing) {
+				exists = true
+				break
+			}
+		}
+
+		if !exists {
+			endpoints = append(endpoints, ep)
+		}
+	}
+
+	state.Endpoints = endpoints
+
+	return state
+}
+
+// sorted returns the overrides in order of set and address.  The lock must be held.
+func (o *Overrides) sorted() []*Override {
+	list := make([]*Override, 0, len(o.list))
+
+	for _, ov := range o.list {
+		list = append(list, ov)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].key() < list[j].key()
+	})
+
+	return list
+}
+
+// save persists the overrides to the Filename, if any.  The lock must be held.
+func (o *Overrides) save() error {
+	if o.Filename == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(o.sorted(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode overrides: %w", err)
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(o.Filename), "."+filepath.Base(o.Filename)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for %s: %w", o.Filename, err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %w", f.Name(), err)
+	}
+
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", f.Name(), err)
+	}
+
+	if err = os.Rename(f.Name(), o.Filename); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", o.Filename, err)
+	}
+
+	return nil
+}
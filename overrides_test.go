@@ -0,0 +1,221 @@
+package dispatchers
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CyCoreSystems/dispatchers/v2/sets"
+)
+
+func testState() *sets.State {
+	return &sets.State{
+		ID: 1,
+		Endpoints: []*sets.Endpoint{
+			{Address: "10.0.0.1", Port: 5060},
+			{Address: "10.0.0.2", Port: 5060},
+			{Address: "10.0.0.2", Port: 5080},
+			{Address: "10.0.0.3", Port: 5060, NotReady: true},
+		},
+	}
+}
+
+func endpointsByString(state *sets.State) map[string]*sets.Endpoint {
+	out := make(map[string]*sets.Endpoint, len(state.Endpoints))
+
+	for _, ep := range state.Endpoints {
+		out[ep.String()] = ep
+	}
+
+	return out
+}
+
+func TestOverridesApply(t *testing.T) {
+	o := new(Overrides)
+
+	expired := time.Now().Add(-time.Minute)
+
+	for _, ov := range []*Override{
+		{Set: 1, Address: "10.0.0.1", Port: 5060, Action: OverrideDisable},
+		{Set: 1, Address: "10.0.0.2", Action: OverrideDrain},
+		{Set: 1, Address: "10.0.0.9", Port: 5060, Action: OverrideAdd},
+		{Set: 1, Address: "10.0.0.3", Port: 5060, Action: OverrideAdd},
+		{Set: 1, Address: "10.0.0.3", Port: 5060, Action: OverrideDisable, Expires: &expired},
+		{Set: 2, Address: "10.0.0.3", Port: 5060, Action: OverrideDisable},
+	} {
+		if err := o.Set(ov); err != nil {
+			t.Fatalf("failed to set override %+v: %v", ov, err)
+		}
+	}
+
+	got := endpointsByString(o.Apply(testState(), nil))
+
+	if _, ok := got["10.0.0.1:5060"]; ok {
+		t.Error("expected the disabled member to be removed")
+	}
+
+	for _, key := range []string{"10.0.0.2:5060", "10.0.0.2:5080"} {
+		ep, ok := got[key]
+		if !ok {
+			t.Errorf("expected drained member %s to remain", key)
+			continue
+		}
+
+		if !ep.Drained || ep.NotReady {
+			t.Errorf("expected member %s to be drained without changing its readiness, got %+v", key, ep)
+		}
+	}
+
+	// The addition of 10.0.0.3 was replaced by an override of the same member which has expired, and the override of set 2 does not apply to set 1.
+	if ep, ok := got["10.0.0.3:5060"]; !ok || !ep.NotReady || ep.Drained {
+		t.Errorf("expected member 10.0.0.3 to be unchanged, got %+v", ep)
+	}
+
+	if ep, ok := got["10.0.0.9:5060"]; !ok || ep.Attributes["override"] != string(OverrideAdd) {
+		t.Errorf("expected member 10.0.0.9 to be added, got %+v", ep)
+	}
+
+	if len(got) != 4 {
+		t.Errorf("expected 4 members, got %d: %v", len(got), got)
+	}
+
+	// Added members are only included if they match.
+	got = endpointsByString(o.Apply(testState(), func(ep *sets.Endpoint) bool { return ep.Address != "10.0.0.9" }))
+
+	if _, ok := got["10.0.0.9:5060"]; ok {
+		t.Error("expected the added member to be excluded")
+	}
+
+	// Overrides of a nil Overrides are a no-op.
+	var none *Overrides
+	if state := none.Apply(testState(), nil); len(state.Endpoints) != 4 {
+		t.Errorf("expected no change without overrides, got %v", state.Endpoints)
+	}
+}
+
+func TestOverridesSetValidation(t *testing.T) {
+	o := new(Overrides)
+
+	for _, ov := range []*Override{
+		{Set: 1, Address: "10.0.0.1", Action: "restart"},
+		{Set: 1, Address: "10.0.0.1", Action: OverrideAdd},
+		{Set: 1, Action: OverrideDisable},
+	} {
+		if err := o.Set(ov); err == nil {
+			t.Errorf("expected override %+v to be refused", ov)
+		}
+	}
+
+	if len(o.List()) != 0 {
+		t.Errorf("expected no overrides, got %v", o.List())
+	}
+}
+
+func TestOverridesExpire(t *testing.T) {
+	dir := t.TempDir()
+
+	o := &Overrides{Filename: filepath.Join(dir, "overrides.json")}
+
+	now := time.Now()
+	soon := now.Add(time.Minute)
+	later := now.Add(time.Hour)
+
+	for _, ov := range []*Override{
+		{Set: 3, Address: "10.0.0.1", Action: OverrideDisable, Expires: &soon},
+		{Set: 1, Address: "10.0.0.1", Action: OverrideDrain, Expires: &soon},
+		{Set: 1, Address: "10.0.0.2", Action: OverrideDrain, Expires: &soon},
+		{Set: 2, Address: "10.0.0.1", Action: OverrideDrain, Expires: &later},
+		{Set: 2, Address: "10.0.0.2", Action: OverrideDisable},
+	} {
+		if err := o.Set(ov); err != nil {
+			t.Fatalf("failed to set override: %v", err)
+		}
+	}
+
+	changed, err := o.Expire(now)
+	if err != nil || len(changed) != 0 {
+		t.Fatalf("expected nothing to expire yet, got %v, %v", changed, err)
+	}
+
+	changed, err = o.Expire(soon)
+	if err != nil {
+		t.Fatalf("failed to expire overrides: %v", err)
+	}
+
+	if len(changed) != 2 || changed[0] != 1 || changed[1] != 3 {
+		t.Errorf("expected sets 1 and 3 to change, got %v", changed)
+	}
+
+	if n := len(o.List()); n != 2 {
+		t.Errorf("expected 2 remaining overrides, got %d", n)
+	}
+
+	// The expiry must be persisted.
+	loaded := &Overrides{Filename: o.Filename}
+	if err = loaded.Load(); err != nil {
+		t.Fatalf("failed to load overrides: %v", err)
+	}
+
+	if n := len(loaded.List()); n != 2 {
+		t.Errorf("expected 2 persisted overrides, got %d", n)
+	}
+}
+
+func TestOverridesLoadSave(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "overrides.json")
+
+	// A missing file is not an error.
+	o := &Overrides{Filename: filename}
+	if err := o.Load(); err != nil {
+		t.Fatalf("failed to load missing overrides: %v", err)
+	}
+
+	expires := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+	if err := o.Set(&Override{Set: 1, Address: "10.0.0.1", Port: 5060, Action: OverrideDrain, Reason: "one-way audio", Expires: &expires}); err != nil {
+		t.Fatalf("failed to set override: %v", err)
+	}
+
+	if err := o.Set(&Override{Set: 1, Address: "10.0.0.9", Port: 5060, Action: OverrideAdd}); err != nil {
+		t.Fatalf("failed to set override: %v", err)
+	}
+
+	// Replacing an override of the same member, by its normalized address, must not add another.
+	if err := o.Set(&Override{Set: 1, Address: "::ffff:10.0.0.1", Port: 5060, Action: OverrideDisable}); err != nil {
+		t.Fatalf("failed to set override: %v", err)
+	}
+
+	if removed, err := o.Remove(1, "10.0.0.9", 5060); err != nil || !removed {
+		t.Fatalf("expected the added member to be removed, got %v, %v", removed, err)
+	}
+
+	if removed, err := o.Remove(1, "10.0.0.9", 5060); err != nil || removed {
+		t.Fatalf("expected nothing to be removed, got %v, %v", removed, err)
+	}
+
+	loaded := &Overrides{Filename: filename}
+	if err := loaded.Load(); err != nil {
+		t.Fatalf("failed to load overrides: %v", err)
+	}
+
+	list := loaded.List()
+	if len(list) != 1 {
+		t.Fatalf("expected 1 override, got %d", len(list))
+	}
+
+	if list[0].Action != OverrideDisable || list[0].Address != "::ffff:10.0.0.1" || list[0].Created.IsZero() {
+		t.Errorf("unexpected override loaded: %+v", list[0])
+	}
+
+	// Overrides which cannot be persisted are not made.
+	broken := &Overrides{Filename: filepath.Join(dir, "missing", "overrides.json")}
+
+	if err := broken.Set(&Override{Set: 1, Address: "10.0.0.1", Action: OverrideDisable}); err == nil {
+		t.Fatal("expected an override which cannot be persisted to fail")
+	}
+
+	if len(broken.List()) != 0 {
+		t.Errorf("expected the failed override to be rolled back, got %v", broken.List())
+	}
+}
@@ -0,0 +1,107 @@
+package sets
+
+import (
+	"strings"
+
+	"inet.af/netaddr"
+)
+
+// ipPort is the key of an index entry:  a normalized IP address and a port.
+type ipPort struct {
+	ip   netaddr.IP
+	port uint32
+}
+
+// index provides lookups of the members of a dispatcher set by address.
+// IP addresses are indexed in their parsed and normalized form, so that differently-formatted representations of the same address, such as "10.0.0.5" and "::ffff:10.0.0.5", match.
+// Members whose addresses are not IP addresses, such as the hostnames of static sets, are indexed by their lower-cased address.
+type index struct {
+	// byIPPort holds the members by address and port.
+	byIPPort map[ipPort][]*Endpoint
+
+	// byIP holds the members by address, on any port.
+	byIP map[netaddr.IP][]*Endpoint
+
+	// byHost holds the members whose addresses are not IP addresses, by lower-cased address.
+	byHost map[string][]*Endpoint
+
+	// ips holds the unique IP addresses of the members, for prefix queries.
+	ips []netaddr.IP
+}
+
+func newIndex(list []*Endpoint) *index {
+	idx := &index{
+		byIPPort: make(map[ipPort][]*Endpoint, len(list)),
+		byIP:     make(map[netaddr.IP][]*Endpoint, len(list)),
+		byHost:   make(map[string][]*Endpoint),
+	}
+
+	for _, ep := range list {
+		ip, ok := ParseIP(ep.Address)
+		if !ok {
+			host := strings.ToLower(ep.Address)
+			idx.byHost[host] = append(idx.byHost[host], ep)
+			continue
+		}
+
+		if _, ok := idx.byIP[ip]; !ok {
+			idx.ips = append(idx.ips, ip)
+		}
+
+		idx.byIP[ip] = append(idx.byIP[ip], ep)
+
+		key := ipPort{ip, ep.Port}
+		idx.byIPPort[key] = append(idx.byIPPort[key], ep)
+	}
+
+	return idx
+}
+
+// IndexState returns a Lookuper of the members of the given State.
+func IndexState(state *State) Lookuper {
+	return newIndex(state.Endpoints)
+}
+
+// Lookup implements Lookuper
+func (idx *index) Lookup(addr string, port uint32) []*Endpoint {
+	ip, ok := ParseIP(addr)
+	if !ok {
+		var out []*Endpoint
+
+		for _, ep := range idx.byHost[strings.ToLower(addr)] {
+			if port == 0 || ep.Port == port {
+				out = append(out, ep)
+			}
+		}
+
+		return out
+	}
+
+	if port == 0 {
+		return idx.byIP[ip]
+	}
+
+	return idx.byIPPort[ipPort{ip, port}]
+}
+
+// LookupPrefix implements Lookuper
+func (idx *index) LookupPrefix(prefix netaddr.IPPrefix) (out []*Endpoint) {
+	for _, ip := range idx.ips {
+		if prefix.Contains(ip) {
+			out = append(out, idx.byIP[ip]...)
+		}
+	}
+
+	return out
+}
+
+// ParseIP parses an IP address into its normalized form for comparison:  IPv4-mapped IPv6 addresses are unmapped to IPv4 and zones are removed.
+// It reports false if the address is not an IP address.
+func ParseIP(addr string) (netaddr.IP, bool) {
+	ip, err := netaddr.ParseIP(strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]"))
+	if err != nil {
+		return netaddr.IP{}, false
+	}
+
+	return ip.Unmap().WithZone(""), true
+}
@@ -3,8 +3,9 @@ package sets
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strconv"
-	"sync"
+	"strings"
 
 	"inet.af/netaddr"
 	v1 "k8s.io/api/core/v1"
@@ -17,12 +18,48 @@ import (
 type Endpoint struct {
 	Address string
 	Port    uint32
+
+	// Attributes is an optional set of descriptive key/value pairs for the
+	// endpoint, such as the name of the Pod or Node which backs it.
+	Attributes map[string]string `json:",omitempty"`
+
+	// NotReady indicates that the endpoint is known to not (yet) be ready to
+	// receive traffic.
+	NotReady bool `json:",omitempty"`
+
+	// Drained indicates that the endpoint has been drained by a manual
+	// override:  it remains a member of its set, but should be sent no new
+	// traffic.
+	Drained bool `json:",omitempty"`
 }
 
 func (ep *Endpoint) String() string {
 	return fmt.Sprintf("%s:%d", formatAddress(ep.Address), ep.Port)
 }
 
+// IsIPv6 indicates whether the address of the endpoint is an IPv6 address, which String formats in brackets.
+func (ep *Endpoint) IsIPv6() bool {
+	ip, err := netaddr.ParseIP(ep.Address)
+
+	return err == nil && ip.Is6()
+}
+
+// FormatAttributes formats endpoint attributes in the `key=value;key=value` form used by kamailio, in order of key.
+func FormatAttributes(attrs map[string]string) string {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+attrs[k])
+	}
+
+	return strings.Join(pairs, ";")
+}
+
 func formatAddress(addr string) string {
 	ip, err := netaddr.ParseIP(addr)
 	if err != nil {
@@ -36,10 +73,14 @@ func formatAddress(addr string) string {
 	return ip.String()
 }
 
+// State is a snapshot of the membership of a dispatcher set.
 type State struct {
 	// ID is the unique identifier of the dispatcher set.
 	ID int
 
+	// Version is incremented on each change of the membership of the dispatcher set.
+	Version uint64 `json:",omitempty"`
+
 	// Endpoints is the set of hosts within the dispatcher set.
 	Endpoints []*Endpoint
 }
@@ -53,63 +94,60 @@ type DispatcherSet interface {
 	// State returns the current state of the DispatcherSet
 	State() *State
 
-	// IsMember checks an address for membership in the set
+	// IsMember checks an address for membership in the set.
+	// IP addresses are compared in their normalized form (see ParseIP).  If port is 0, any port matches.
 	IsMember(address string, port uint32) bool
 
 	// RegisterChangeFunc registers a callback function which will be invoked whenever the DispatcherSet contents changes.
 	RegisterChangeFunc(func(*State))
 }
 
-// StaticSet represents a dispatcher set whose members are static or manually defined
-type staticSet struct {
-	id        int
-	Endpoints []*Endpoint
+// A Lookuper is a DispatcherSet which indexes its members by address.
+// Sets which do not implement Lookuper are looked up by indexing their State on each lookup (see IndexState).
+type Lookuper interface {
+	// Lookup returns the members of the set with the given address and, if port is non-zero, port.
+	// The members are shared and must not be modified.
+	Lookup(address string, port uint32) []*Endpoint
+
+	// LookupPrefix returns the members of the set whose IP addresses are within the given prefix (CIDR).
+	// The members are shared and must not be modified.
+	LookupPrefix(prefix netaddr.IPPrefix) []*Endpoint
 }
 
-func (s *staticSet) Close() {}
+// A Syncer is a DispatcherSet whose membership is loaded asynchronously, such as from Kubernetes.
+type Syncer interface {
+	// HasSynced indicates whether the initial membership of the set has been loaded.
+	HasSynced() bool
+}
 
-func (s *staticSet) State() *State {
-	return &State{
-		ID:        s.id,
-		Endpoints: s.Endpoints,
+// HasSynced indicates whether the initial membership of the given DispatcherSet has been loaded.
+// Sets which do not implement Syncer are always considered to be synced.
+func HasSynced(set DispatcherSet) bool {
+	if s, ok := set.(Syncer); ok {
+		return s.HasSynced()
 	}
-}
 
-func (s *staticSet) IsMember(addr string, port uint32) bool {
-	for _, m := range s.Endpoints {
-		if m.Address == addr {
+	return true
+}
 
-			if port > 0 {
-				if m.Port != port {
-					return false
-				}
-			}
-			return true
-		}
-	}
-	return false
+// StaticSet represents a dispatcher set whose members are static or manually defined
+type staticSet struct {
+	*publisher
 }
 
-func (s *staticSet) RegisterChangeFunc(f func(*State)) {}
+func (s *staticSet) Close() {}
 
 // NewStaticSet returns a new statically-defined dispatcher set
 func NewStaticSet(id int, endpoints []*Endpoint) DispatcherSet {
 	return &staticSet{
-		id:        id,
-		Endpoints: endpoints,
+		publisher: newPublisher(id, endpoints),
 	}
 }
 
 // kubernetesSet represents a dispatcher set whose
 // data should be derived from Kubernetes.
 type kubernetesSet struct {
-	// id is the dispatch set index for this set
-	id int
-
-	endpoints []*Endpoint
-
-	// callbacks is the set of functions which should be called when the endpoint membership changes.
-	callbacks []func(*State)
+	*publisher
 
 	// name is the name of the Kubernetes Endpoint List
 	// from which the dispatcher endpoints should be derived.
@@ -121,7 +159,8 @@ type kubernetesSet struct {
 
 	port string
 
-	mu sync.Mutex
+	// synced reports whether the informer has completed its initial list.
+	synced cache.InformerSynced
 }
 
 // NewKubernetesSet returns a new kubernetes-based dispatcher set.
@@ -134,13 +173,14 @@ type kubernetesSet struct {
 //
 //  * `port` is the port reference of the SIP endpoints this set describes.  This is optional, and if not specified, will default to "5060".
 //
+// Deprecated: each set created this way handles every EndpointSlice of the informer, and only the most recently changed EndpointSlice of the Service is used.  Use a KubernetesWatcher instead.
 func NewKubernetesSet(ctx context.Context, f informers.SharedInformerFactory, setID int, namespace, name, port string) (DispatcherSet, error) {
 	if port == "" {
 		port = "5060"
 	}
 
 	s := &kubernetesSet{
-		id:        setID,
+		publisher: newPublisher(setID, nil),
 		namespace: namespace,
 		name:      name,
 		port:      port,
@@ -154,7 +194,9 @@ func NewKubernetesSet(ctx context.Context, f informers.SharedInformerFactory, se
 		DeleteFunc: s.deleteFunc,
 	})
 
-	go informer.Informer().Run(ctx.Done())
+	s.synced = informer.Informer().HasSynced
+
+	f.Start(ctx.Done())
 
 	return s, nil
 }
@@ -183,23 +225,7 @@ func (s *kubernetesSet) updateSet(obj interface{}) {
 		return
 	}
 
-	s.mu.Lock()
-	if !isChanged(s.endpoints, list) {
-		s.mu.Unlock()
-		return
-	}
-
-	s.endpoints = list
-	s.mu.Unlock()
-
-	state := &State{
-		ID:        s.id,
-		Endpoints: list,
-	}
-
-	for _, f := range s.callbacks {
-		f(state)
-	}
+	s.update(list)
 }
 
 func (s *kubernetesSet) addFunc(obj interface{}) {
@@ -216,46 +242,14 @@ func (s *kubernetesSet) deleteFunc(obj interface{}) {
 
 func (s *kubernetesSet) Close() {}
 
-func (s *kubernetesSet) State() *State {
-	return &State{
-		ID:        s.id,
-		Endpoints: s.endpoints,
-	}
-}
-
-func (s *kubernetesSet) RegisterChangeFunc(f func(*State)) {
-	s.mu.Lock()
-
-	s.callbacks = append(s.callbacks, f)
-
-	defer s.mu.Unlock()
-}
-
-func (s *kubernetesSet) IsMember(addr string, port uint32) bool {
-	for _, ep := range s.endpoints {
-		if ep.Address == addr {
-
-			if port > 0 {
-				if ep.Port != port {
-					return false
-				}
-			}
-			return true
-		}
-	}
-	return false
+func (s *kubernetesSet) HasSynced() bool {
+	return s.synced()
 }
 
 // legacyKubernetesSet represents a dispatcher set whose
 // data should be derived from Kubernetes.
 type legacyKubernetesSet struct {
-	// id is the dispatch set index for this set
-	id int
-
-	endpoints []*Endpoint
-
-	// callbacks is the set of functions which should be called when the endpoint membership changes.
-	callbacks []func(*State)
+	*publisher
 
 	// name is the name of the Kubernetes Endpoint List
 	// from which the dispatcher endpoints should be derived.
@@ -267,7 +261,8 @@ type legacyKubernetesSet struct {
 
 	port string
 
-	mu sync.Mutex
+	// synced reports whether the informer has completed its initial list.
+	synced cache.InformerSynced
 }
 
 // NewLegacyKunbernetesSet returns a new Kubernetes-based dispatcher set using the older Endpoints method.
@@ -281,13 +276,14 @@ type legacyKubernetesSet struct {
 //
 //  * `port` is the port reference of the SIP endpoints this set describes.  This is optional, and if not specified, will default to "5060".
 //
+// Deprecated: each set created this way handles every Endpoints of the informer.  Use a KubernetesWatcher with Legacy set instead.
 func NewLegacyKubernetesSet(ctx context.Context, f informers.SharedInformerFactory, setID int, namespace, name, port string) (DispatcherSet, error) {
 	if port == "" {
 		port = "5060"
 	}
 
 	s := &legacyKubernetesSet{
-		id:        setID,
+		publisher: newPublisher(setID, nil),
 		namespace: namespace,
 		name:      name,
 		port:      port,
@@ -301,7 +297,9 @@ func NewLegacyKubernetesSet(ctx context.Context, f informers.SharedInformerFacto
 		DeleteFunc: s.deleteFunc,
 	})
 
-	go informer.Informer().Run(ctx.Done())
+	s.synced = informer.Informer().HasSynced
+
+	f.Start(ctx.Done())
 
 	return s, nil
 }
@@ -325,23 +323,7 @@ func (s *legacyKubernetesSet) updateSet(obj interface{}) {
 		return
 	}
 
-	s.mu.Lock()
-	if !isChanged(s.endpoints, list) {
-		s.mu.Unlock()
-		return
-	}
-
-	s.endpoints = list
-	s.mu.Unlock()
-
-	state := &State{
-		ID:        s.id,
-		Endpoints: list,
-	}
-
-	for _, f := range s.callbacks {
-		f(state)
-	}
+	s.update(list)
 }
 
 func (s *legacyKubernetesSet) addFunc(obj interface{}) {
@@ -358,34 +340,8 @@ func (s *legacyKubernetesSet) deleteFunc(obj interface{}) {
 
 func (s *legacyKubernetesSet) Close() {}
 
-func (s *legacyKubernetesSet) State() *State {
-	return &State{
-		ID:        s.id,
-		Endpoints: s.endpoints,
-	}
-}
-
-func (s *legacyKubernetesSet) RegisterChangeFunc(f func(*State)) {
-	s.mu.Lock()
-
-	s.callbacks = append(s.callbacks, f)
-
-	defer s.mu.Unlock()
-}
-
-func (s *legacyKubernetesSet) IsMember(addr string, port uint32) bool {
-	for _, ep := range s.endpoints {
-		if ep.Address == addr {
-
-			if port > 0 {
-				if ep.Port != port {
-					return false
-				}
-			}
-			return true
-		}
-	}
-	return false
+func (s *legacyKubernetesSet) HasSynced() bool {
+	return s.synced()
 }
 
 func flattenEndpoints(refPort string, epList *v1.Endpoints) (out []*Endpoint, err error) {
@@ -418,8 +374,9 @@ func flattenEndpoints(refPort string, epList *v1.Endpoints) (out []*Endpoint, er
 
 		for _, addr := range ss.Addresses {
 			out = append(out, &Endpoint{
-				Address: addr.IP,
-				Port:    portNumber,
+				Address:    addr.IP,
+				Port:       portNumber,
+				Attributes: endpointAttributes(addr.TargetRef, addr.NodeName, nil),
 			})
 		}
 	}
@@ -455,8 +412,10 @@ func flattenEndpointSlice(refPort string, epSlice *discoveryv1.EndpointSlice) (o
 	for _, n := range epSlice.Endpoints {
 		for _, addr := range n.Addresses {
 			out = append(out, &Endpoint{
-				Address: addr,
-				Port:    uint32(portNumber),
+				Address:    addr,
+				Port:       uint32(portNumber),
+				Attributes: endpointAttributes(n.TargetRef, n.NodeName, n.Zone),
+				NotReady:   n.Conditions.Ready != nil && !*n.Conditions.Ready,
 			})
 		}
 	}
@@ -464,26 +423,90 @@ func flattenEndpointSlice(refPort string, epSlice *discoveryv1.EndpointSlice) (o
 	return out, nil
 }
 
+// endpointAttributes returns the descriptive attributes of a Kubernetes endpoint.
+func endpointAttributes(ref *v1.ObjectReference, nodeName *string, zone *string) map[string]string {
+	attrs := make(map[string]string)
+
+	if ref != nil && ref.Kind == "Pod" {
+		attrs["pod"] = ref.Name
+	}
+
+	if nodeName != nil && *nodeName != "" {
+		attrs["node"] = *nodeName
+	}
+
+	if zone != nil && *zone != "" {
+		attrs["zone"] = *zone
+	}
+
+	if len(attrs) == 0 {
+		return nil
+	}
+
+	return attrs
+}
+
+// isChanged indicates whether the membership of a set, including the readiness and attributes of its members, differs between its previous and current state.
 func isChanged(previous []*Endpoint, current []*Endpoint) (changed bool) {
 	if len(previous) != len(current) {
 		return true
 	}
 
+	prev := make(map[string]*Endpoint, len(previous))
 	for _, p := range previous {
-		var found bool
+		prev[p.String()] = p
+	}
 
-		for _, c := range current {
-			if c.Address == p.Address &&
-				c.Port == p.Port {
-				found = true
-				break
-			}
+	for _, c := range current {
+		p, ok := prev[c.String()]
+		if !ok {
+			return true
+		}
+
+		if p.NotReady != c.NotReady || !equalAttributes(p.Attributes, c.Attributes) {
+			return true
 		}
+	}
+
+	return false
+}
+
+func equalAttributes(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
 
-		if !found {
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
 			return false
 		}
 	}
 
 	return true
 }
+
+// Diff returns the endpoints which have been added to and removed from a set between its previous and current membership.
+// Endpoints are compared by their address and port.
+func Diff(previous []*Endpoint, current []*Endpoint) (added []*Endpoint, removed []*Endpoint) {
+	prev := make(map[string]bool, len(previous))
+	for _, p := range previous {
+		prev[p.String()] = true
+	}
+
+	cur := make(map[string]bool, len(current))
+	for _, c := range current {
+		cur[c.String()] = true
+
+		if !prev[c.String()] {
+			added = append(added, c)
+		}
+	}
+
+	for _, p := range previous {
+		if !cur[p.String()] {
+			removed = append(removed, p)
+		}
+	}
+
+	return added, removed
+}
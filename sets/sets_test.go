@@ -0,0 +1,21 @@
+package sets
+
+import "testing"
+
+func TestFormatAttributes(t *testing.T) {
+	tests := []struct {
+		attrs map[string]string
+		want  string
+	}{
+		{nil, ""},
+		{map[string]string{}, ""},
+		{map[string]string{"pod": "kamailio-0"}, "pod=kamailio-0"},
+		{map[string]string{"zone": "us-east-1a", "pod": "kamailio-0", "node": "worker-1"}, "node=worker-1;pod=kamailio-0;zone=us-east-1a"},
+	}
+
+	for _, tt := range tests {
+		if got := FormatAttributes(tt.attrs); got != tt.want {
+			t.Errorf("FormatAttributes(%v) = %q, want %q", tt.attrs, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package sets
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"inet.af/netaddr"
+)
+
+// Copy returns a deep copy of the State, which may be modified freely.
+func (s *State) Copy() *State {
+	if s == nil {
+		return nil
+	}
+
+	out := &State{
+		ID:      s.ID,
+		Version: s.Version,
+	}
+
+	if s.Endpoints != nil {
+		out.Endpoints = make([]*Endpoint, len(s.Endpoints))
+
+		for i, ep := range s.Endpoints {
+			out.Endpoints[i] = ep.Copy()
+		}
+	}
+
+	return out
+}
+
+// Copy returns a deep copy of the Endpoint.
+func (ep *Endpoint) Copy() *Endpoint {
+	if ep == nil {
+		return nil
+	}
+
+	out := *ep
+
+	if ep.Attributes != nil {
+		out.Attributes = make(map[string]string, len(ep.Attributes))
+
+		for k, v := range ep.Attributes {
+			out.Attributes[k] = v
+		}
+	}
+
+	return &out
+}
+
+// publisher holds the state of a dispatcher set as an immutable, versioned snapshot and informs the registered callbacks of each new snapshot.
+// Readers load the current snapshot without locking; changes are serialized, so callbacks are invoked once for each version, in order.
+// Callbacks are invoked without holding mu, so that they may read the set and register further callbacks (as when a set is added to a Controller during an update) without deadlocking.
+type publisher struct {
+	// id is the dispatch set index for this set
+	id int
+
+	// current is the current *snapshot of the set, which must never be modified once it has been stored.
+	current atomic.Value
+
+	// callbacks is the set of functions which should be called when the endpoint membership changes.
+	callbacks []func(*State)
+
+	// updateMu serializes updates, and the invocation of the callbacks for them.
+	updateMu sync.Mutex
+
+	// mu protects callbacks.
+	mu sync.Mutex
+}
+
+func newPublisher(id int, endpoints []*Endpoint) *publisher {
+	p := &publisher{
+		id: id,
+	}
+
+	p.store(&State{
+		ID:        id,
+		Endpoints: copyEndpoints(endpoints),
+	})
+
+	return p
+}
+
+// snapshot is an immutable state of a set along with its index.
+type snapshot struct {
+	state *State
+	index *index
+}
+
+func (p *publisher) store(state *State) {
+	p.current.Store(&snapshot{
+		state: state,
+		index: newIndex(state.Endpoints),
+	})
+}
+
+func (p *publisher) snapshot() *snapshot {
+	return p.current.Load().(*snapshot)
+}
+
+// State returns the current snapshot of the set.
+// The snapshot is shared and must not be modified; use State.Copy to obtain a modifiable copy.
+func (p *publisher) State() *State {
+	return p.snapshot().state
+}
+
+// RegisterChangeFunc registers a callback function which will be invoked with each new snapshot of the set.
+func (p *publisher) RegisterChangeFunc(f func(*State)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.callbacks = append(p.callbacks, f)
+}
+
+// IsMember checks an address for membership in the set.
+// If port is 0, any port matches.
+func (p *publisher) IsMember(addr string, port uint32) bool {
+	return len(p.Lookup(addr, port)) > 0
+}
+
+// Lookup returns the members of the set with the given address and, if port is non-zero, port.
+func (p *publisher) Lookup(addr string, port uint32) []*Endpoint {
+	return p.snapshot().index.Lookup(addr, port)
+}
+
+// LookupPrefix returns the members of the set whose IP addresses are within the given prefix.
+func (p *publisher) LookupPrefix(prefix netaddr.IPPrefix) []*Endpoint {
+	return p.snapshot().index.LookupPrefix(prefix)
+}
+
+// update publishes a new snapshot with the given members, informing the callbacks, if they differ from those of the current snapshot.
+func (p *publisher) update(list []*Endpoint) {
+	p.updateMu.Lock()
+	defer p.updateMu.Unlock()
+
+	current := p.State()
+
+	if !isChanged(current.Endpoints, list) {
+		return
+	}
+
+	state := &State{
+		ID:        p.id,
+		Version:   current.Version + 1,
+		Endpoints: copyEndpoints(list),
+	}
+
+	p.store(state)
+
+	p.mu.Lock()
+	callbacks := make([]func(*State), len(p.callbacks))
+	copy(callbacks, p.callbacks)
+	p.mu.Unlock()
+
+	for _, f := range callbacks {
+		f(state)
+	}
+}
+
+func copyEndpoints(list []*Endpoint) []*Endpoint {
+	if list == nil {
+		return nil
+	}
+
+	out := make([]*Endpoint, len(list))
+
+	for i, ep := range list {
+		out[i] = ep.Copy()
+	}
+
+	return out
+}
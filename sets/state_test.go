@@ -0,0 +1,156 @@
+package sets
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"inet.af/netaddr"
+)
+
+func members(n int) []*Endpoint {
+	list := make([]*Endpoint, n)
+
+	for i := range list {
+		list[i] = &Endpoint{Address: fmt.Sprintf("10.0.0.%d", i+1), Port: 5060}
+	}
+
+	return list
+}
+
+// TestPublisherConcurrency must be run with -race.
+func TestPublisherConcurrency(t *testing.T) {
+	p := newPublisher(1, nil)
+
+	var (
+		mu       sync.Mutex
+		versions = make(map[int][]uint64)
+	)
+
+	register := func(id int) {
+		p.RegisterChangeFunc(func(state *State) {
+			mu.Lock()
+			versions[id] = append(versions[id], state.Version)
+			mu.Unlock()
+
+			// Callbacks may read the set.
+			p.State()
+			p.Lookup("10.0.0.1", 5060)
+		})
+	}
+
+	register(0)
+
+	prefix := netaddr.MustParseIPPrefix("10.0.0.0/24")
+
+	var wg sync.WaitGroup
+
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+
+		go func(w int) {
+			defer wg.Done()
+
+			for i := 0; i < 200; i++ {
+				p.update(members((w+i)%5 + 1))
+			}
+		}(w)
+	}
+
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := 0; i < 500; i++ {
+				state := p.State()
+				if len(state.Endpoints) > 0 && state.Endpoints[0] == nil {
+					t.Error("snapshot contains a nil member")
+				}
+
+				p.IsMember("10.0.0.1", 0)
+				p.LookupPrefix(prefix)
+			}
+		}()
+	}
+
+	for id := 1; id <= 4; id++ {
+		wg.Add(1)
+
+		go func(id int) {
+			defer wg.Done()
+
+			register(id)
+		}(id)
+	}
+
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	final := p.State().Version
+
+	if got := versions[0]; len(got) != int(final) {
+		t.Errorf("expected the first callback to be invoked for each of %d versions, got %d invocations", final, len(got))
+	}
+
+	for id, list := range versions {
+		for i := 1; i < len(list); i++ {
+			if list[i] != list[i-1]+1 {
+				t.Errorf("callback %d was invoked out of order: version %d followed %d", id, list[i], list[i-1])
+				break
+			}
+		}
+	}
+}
+
+// TestPublisherRegisterDuringUpdate reproduces the lock order of a Controller which adds a set (holding its own lock, then registering its callback) while a callback of an update of the set waits for the Controller's lock.
+func TestPublisherRegisterDuringUpdate(t *testing.T) {
+	p := newPublisher(1, nil)
+
+	var controllerMu sync.RWMutex
+
+	inCallback := make(chan struct{})
+
+	p.RegisterChangeFunc(func(*State) {
+		close(inCallback)
+
+		controllerMu.RLock()
+		controllerMu.RUnlock()
+	})
+
+	controllerMu.Lock()
+
+	updated := make(chan struct{})
+
+	go func() {
+		p.update(members(1))
+		close(updated)
+	}()
+
+	<-inCallback
+
+	registered := make(chan struct{})
+
+	go func() {
+		p.RegisterChangeFunc(func(*State) {})
+		close(registered)
+	}()
+
+	select {
+	case <-registered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("registration blocked on the invocation of the callbacks")
+	}
+
+	controllerMu.Unlock()
+
+	select {
+	case <-updated:
+	case <-time.After(5 * time.Second):
+		t.Fatal("update did not complete")
+	}
+}
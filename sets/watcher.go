@@ -0,0 +1,310 @@
+package sets
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// serviceIndex is the name of the informer index which keys endpoint objects by the namespace/name of their Service.
+const serviceIndex = "service"
+
+// DefaultResyncPeriod is the resync period of the informers of a KubernetesWatcher which specifies none.
+const DefaultResyncPeriod = 10 * time.Minute
+
+// KubernetesWatcher watches the endpoints of Kubernetes Services on behalf of any number of dispatcher sets.
+// A single informer is used for each namespace of its sets, and each change is dispatched, through an index keyed by the namespace and name of the Service, only to the sets derived from that Service.
+// Since nothing is watched outside of those namespaces, a namespaced Role in each of them is sufficient.
+//
+// Create the sets with NewSet, then call Start.
+type KubernetesWatcher struct {
+
+	// Client is the Kubernetes client with which to watch the endpoints.
+	Client kubernetes.Interface
+
+	// Legacy indicates that the older Endpoints should be watched instead of EndpointSlices, for Kubernetes earlier than v1.21.
+	Legacy bool
+
+	// SelectServices restricts the informers, using a label selector on kubernetes.io/service-name, to the EndpointSlices of the Services of the sets, instead of all EndpointSlices of their namespaces.
+	// It has no effect on legacy Endpoints.
+	SelectServices bool
+
+	// ResyncPeriod is the resync period of the informers.  If not specified, DefaultResyncPeriod is used.
+	ResyncPeriod time.Duration
+
+	// sets are the dispatcher sets of the watcher, keyed by the namespace/name of their Service.
+	sets map[string][]*kubernetesSet
+
+	// informers are the informers of the watcher, keyed by namespace.
+	informers map[string]cache.SharedIndexInformer
+
+	mu sync.RWMutex
+}
+
+// NewSet returns a new dispatcher set whose members are the endpoints of the given Service.
+// It must be called before the watcher is started.
+//
+//  * `setID` is the dispatcher set's id
+//
+//  * `namespace` is the namespace of the Service whose endpoints will describe this dispatcher set.
+//
+//  * `name` is the name of the Service whose endpoints will describe this dispatcher set.
+//
+//  * `port` is the port reference of the SIP endpoints this set describes.  This is optional, and if not specified, will default to "5060".
+//
+func (w *KubernetesWatcher) NewSet(setID int, namespace, name, port string) (DispatcherSet, error) {
+	if port == "" {
+		port = "5060"
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.informers != nil {
+		return nil, fmt.Errorf("watcher has already been started")
+	}
+
+	if w.sets == nil {
+		w.sets = make(map[string][]*kubernetesSet)
+	}
+
+	s := &kubernetesSet{
+		publisher: newPublisher(setID, nil),
+		namespace: namespace,
+		name:      name,
+		port:      port,
+		synced:    w.HasSynced,
+	}
+
+	key := namespace + "/" + name
+
+	w.sets[key] = append(w.sets[key], s)
+
+	return s, nil
+}
+
+// Start starts an informer for each namespace of the sets of the watcher, which run until the context is cancelled.
+func (w *KubernetesWatcher) Start(ctx context.Context) error {
+	if w.Client == nil {
+		return fmt.Errorf("kubernetes client is nil")
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.informers != nil {
+		return fmt.Errorf("watcher has already been started")
+	}
+
+	resync := w.ResyncPeriod
+	if resync == 0 {
+		resync = DefaultResyncPeriod
+	}
+
+	services := make(map[string][]string)
+
+	for _, list := range w.sets {
+		services[list[0].namespace] = append(services[list[0].namespace], list[0].name)
+	}
+
+	factories := make([]informers.SharedInformerFactory, 0, len(services))
+	w.informers = make(map[string]cache.SharedIndexInformer, len(services))
+
+	for ns, names := range services {
+		opts := []informers.SharedInformerOption{
+			informers.WithNamespace(ns),
+		}
+
+		if w.SelectServices && !w.Legacy {
+			selector, err := serviceSelector(names)
+			if err != nil {
+				return err
+			}
+
+			opts = append(opts, informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+				o.LabelSelector = selector
+			}))
+		}
+
+		f := informers.NewSharedInformerFactoryWithOptions(w.Client, resync, opts...)
+
+		var informer cache.SharedIndexInformer
+
+		if w.Legacy {
+			informer = f.Core().V1().Endpoints().Informer()
+		} else {
+			informer = f.Discovery().V1().EndpointSlices().Informer()
+		}
+
+		if err := informer.AddIndexers(cache.Indexers{serviceIndex: serviceIndexFunc}); err != nil {
+			return fmt.Errorf("failed to add service index: %w", err)
+		}
+
+		informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    w.handle,
+			UpdateFunc: func(old interface{}, obj interface{}) { w.handle(obj) },
+			DeleteFunc: w.handle,
+		})
+
+		w.informers[ns] = informer
+
+		factories = append(factories, f)
+	}
+
+	for _, f := range factories {
+		f.Start(ctx.Done())
+	}
+
+	return nil
+}
+
+// HasSynced indicates whether the watcher has been started and all of its informers have completed their initial lists.
+func (w *KubernetesWatcher) HasSynced() bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.informers == nil {
+		return false
+	}
+
+	for _, informer := range w.informers {
+		if !informer.HasSynced() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// serviceSelector returns a label selector which matches the EndpointSlices of the given Services.
+func serviceSelector(names []string) (string, error) {
+	values := append([]string(nil), names...)
+
+	sort.Strings(values)
+
+	req, err := labels.NewRequirement(discoveryv1.LabelServiceName, selection.In, values)
+	if err != nil {
+		return "", fmt.Errorf("failed to build service selector: %w", err)
+	}
+
+	return labels.NewSelector().Add(*req).String(), nil
+}
+
+// handle updates the sets of the Service of a changed endpoint object from all of the endpoint objects of that Service.
+func (w *KubernetesWatcher) handle(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	keys, err := serviceIndexFunc(obj)
+	if err != nil || len(keys) == 0 {
+		return
+	}
+
+	w.mu.RLock()
+	list := w.sets[keys[0]]
+	informer := w.informers[namespaceOf(obj)]
+	w.mu.RUnlock()
+
+	if len(list) == 0 || informer == nil {
+		return
+	}
+
+	objs, err := informer.GetIndexer().ByIndex(serviceIndex, keys[0])
+	if err != nil {
+		return
+	}
+
+	// Order the endpoint objects so that the order of the members is stable.
+	sort.Slice(objs, func(i, j int) bool {
+		return objectName(objs[i]) < objectName(objs[j])
+	})
+
+	for _, s := range list {
+		s.update(flattenObjects(s.port, objs))
+	}
+}
+
+// serviceIndexFunc indexes EndpointSlices and Endpoints by the namespace/name of their Service.
+func serviceIndexFunc(obj interface{}) ([]string, error) {
+	switch o := obj.(type) {
+	case *discoveryv1.EndpointSlice:
+		svcName, ok := o.Labels[discoveryv1.LabelServiceName]
+		if !ok {
+			return nil, nil
+		}
+
+		return []string{o.Namespace + "/" + svcName}, nil
+	case *v1.Endpoints:
+		return []string{o.Namespace + "/" + o.Name}, nil
+	default:
+		return nil, nil
+	}
+}
+
+func namespaceOf(obj interface{}) string {
+	switch o := obj.(type) {
+	case *discoveryv1.EndpointSlice:
+		return o.Namespace
+	case *v1.Endpoints:
+		return o.Namespace
+	default:
+		return ""
+	}
+}
+
+func objectName(obj interface{}) string {
+	switch o := obj.(type) {
+	case *discoveryv1.EndpointSlice:
+		return o.Name
+	case *v1.Endpoints:
+		return o.Name
+	default:
+		return ""
+	}
+}
+
+// flattenObjects returns the unique endpoints of a set of EndpointSlices or Endpoints.
+// Objects which do not define the port are skipped.
+func flattenObjects(refPort string, objs []interface{}) (out []*Endpoint) {
+	seen := make(map[string]bool)
+
+	for _, obj := range objs {
+		var list []*Endpoint
+		var err error
+
+		switch o := obj.(type) {
+		case *discoveryv1.EndpointSlice:
+			list, err = flattenEndpointSlice(refPort, o)
+		case *v1.Endpoints:
+			list, err = flattenEndpoints(refPort, o)
+		default:
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		for _, ep := range list {
+			if seen[ep.String()] {
+				continue
+			}
+			seen[ep.String()] = true
+
+			out = append(out, ep)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,349 @@
+package sets
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// testSlice returns an EndpointSlice of the given Service with a "sip" port of 5060 and an endpoint for each address.
+func testSlice(namespace, name, service string, ready bool, addrs ...string) *discoveryv1.EndpointSlice {
+	portName := "sip"
+	port := int32(5060)
+
+	slice := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+			Labels:    map[string]string{discoveryv1.LabelServiceName: service},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Ports:       []discoveryv1.EndpointPort{{Name: &portName, Port: &port}},
+	}
+
+	for _, addr := range addrs {
+		slice.Endpoints = append(slice.Endpoints, discoveryv1.Endpoint{
+			Addresses:  []string{addr},
+			Conditions: discoveryv1.EndpointConditions{Ready: &ready},
+		})
+	}
+
+	return slice
+}
+
+// waitFor waits for cond to be met, failing the test if it is not met in time.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// memberList formats the members of a set as a sorted, comma-separated list, with a "!" suffix for members which are not ready.
+func memberList(s DispatcherSet) string {
+	var list []string
+
+	for _, ep := range s.State().Endpoints {
+		if ep.NotReady {
+			list = append(list, ep.String()+"!")
+		} else {
+			list = append(list, ep.String())
+		}
+	}
+
+	sort.Strings(list)
+
+	return strings.Join(list, ",")
+}
+
+// listSelectors returns the label selector of each list of the given resource made through the fake client, by namespace.
+func listSelectors(kc *fake.Clientset, resource string) map[string]string {
+	out := make(map[string]string)
+
+	for _, action := range kc.Actions() {
+		list, ok := action.(k8stesting.ListAction)
+		if !ok || action.GetVerb() != "list" || action.GetResource().Resource != resource {
+			continue
+		}
+
+		out[list.GetNamespace()] = list.GetListRestrictions().Labels.String()
+	}
+
+	return out
+}
+
+func TestKubernetesWatcher(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	kc := fake.NewSimpleClientset(
+		testSlice("voice", "asterisk-b", "asterisk", true, "10.0.0.2"),
+		testSlice("voice", "asterisk-a", "asterisk", true, "10.0.0.1"),
+		testSlice("voice", "other", "other", true, "10.0.9.1"),
+		testSlice("edge", "proxy", "proxy", true, "10.1.0.1"),
+	)
+
+	w := &KubernetesWatcher{Client: kc}
+
+	asterisk, err := w.NewSet(1, "voice", "asterisk", "sip")
+	if err != nil {
+		t.Fatalf("failed to create set: %v", err)
+	}
+
+	asteriskAlt, err := w.NewSet(3, "voice", "asterisk", "5080")
+	if err != nil {
+		t.Fatalf("failed to create set: %v", err)
+	}
+
+	proxy, err := w.NewSet(2, "edge", "proxy", "")
+	if err != nil {
+		t.Fatalf("failed to create set: %v", err)
+	}
+
+	if w.HasSynced() || HasSynced(asterisk) {
+		t.Fatal("expected the watcher to not be synced before it is started")
+	}
+
+	changes := make(chan int, 100)
+	for _, s := range []DispatcherSet{asterisk, asteriskAlt, proxy} {
+		s.RegisterChangeFunc(func(state *State) {
+			changes <- state.ID
+		})
+	}
+
+	if err = w.Start(ctx); err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+
+	if _, err = w.NewSet(4, "voice", "late", ""); err == nil {
+		t.Error("expected a set created after the start to be refused")
+	}
+
+	if err = w.Start(ctx); err == nil {
+		t.Error("expected a second start to be refused")
+	}
+
+	waitFor(t, "the watcher to sync", w.HasSynced)
+
+	if !HasSynced(asterisk) || !HasSynced(proxy) {
+		t.Error("expected the sets to be synced with the watcher")
+	}
+
+	// A single informer is used for each namespace, and nothing is listed outside of them.
+	selectors := listSelectors(kc, "endpointslices")
+	if len(selectors) != 2 || selectors["voice"] != "" || selectors["edge"] != "" {
+		t.Errorf("expected unfiltered lists of the voice and edge namespaces only, got %v", selectors)
+	}
+
+	// The members of all EndpointSlices of a Service are combined.
+	waitFor(t, "the initial members", func() bool {
+		return memberList(asterisk) == "10.0.0.1:5060,10.0.0.2:5060" &&
+			memberList(asteriskAlt) == "10.0.0.1:5080,10.0.0.2:5080" &&
+			memberList(proxy) == "10.1.0.1:5060"
+	})
+
+	if eps := asterisk.State().Endpoints; eps[0].Address != "10.0.0.1" {
+		t.Errorf("expected the members to be ordered by EndpointSlice name, got %v", eps)
+	}
+
+	for len(changes) > 0 {
+		<-changes
+	}
+
+	slices := kc.DiscoveryV1().EndpointSlices("voice")
+
+	// A change of another Service of the namespace is not dispatched to the sets;  since the informer handles the changes of its namespace in order, the following change of the asterisk Service is the first the sets see.
+	if _, err = slices.Update(ctx, testSlice("voice", "other", "other", true, "10.0.9.1", "10.0.9.2"), metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update EndpointSlice: %v", err)
+	}
+
+	if _, err = slices.Update(ctx, testSlice("voice", "asterisk-b", "asterisk", false, "10.0.0.2"), metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update EndpointSlice: %v", err)
+	}
+
+	waitFor(t, "the readiness change", func() bool {
+		return memberList(asterisk) == "10.0.0.1:5060,10.0.0.2:5060!"
+	})
+
+	waitFor(t, "both sets of the asterisk Service to change", func() bool {
+		return len(changes) == 2
+	})
+
+	for _, id := range []int{<-changes, <-changes} {
+		if id != 1 && id != 3 {
+			t.Errorf("expected only the sets of the asterisk Service to change, got set %d", id)
+		}
+	}
+
+	// Deleting an EndpointSlice removes only its members.
+	if err = slices.Delete(ctx, "asterisk-a", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("failed to delete EndpointSlice: %v", err)
+	}
+
+	waitFor(t, "the deletion of asterisk-a", func() bool {
+		return memberList(asterisk) == "10.0.0.2:5060!"
+	})
+
+	if err = slices.Delete(ctx, "asterisk-b", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("failed to delete EndpointSlice: %v", err)
+	}
+
+	waitFor(t, "the deletion of asterisk-b", func() bool {
+		return len(asterisk.State().Endpoints) == 0 && len(asteriskAlt.State().Endpoints) == 0
+	})
+
+	// EndpointSlices which are added later are picked up.
+	if _, err = kc.DiscoveryV1().EndpointSlices("edge").Create(ctx, testSlice("edge", "proxy-2", "proxy", true, "10.1.0.2"), metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create EndpointSlice: %v", err)
+	}
+
+	waitFor(t, "the addition of proxy-2", func() bool {
+		return memberList(proxy) == "10.1.0.1:5060,10.1.0.2:5060"
+	})
+
+	if len(asterisk.State().Endpoints) != 0 {
+		t.Errorf("expected the asterisk set to be unaffected, got %v", asterisk.State().Endpoints)
+	}
+}
+
+func TestKubernetesWatcherSelectServices(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	kc := fake.NewSimpleClientset(
+		testSlice("voice", "asterisk", "asterisk", true, "10.0.0.1"),
+		testSlice("voice", "media", "media", true, "10.0.1.1"),
+		testSlice("voice", "other", "other", true, "10.0.9.1"),
+		testSlice("edge", "proxy", "proxy", true, "10.1.0.1"),
+	)
+
+	w := &KubernetesWatcher{Client: kc, SelectServices: true}
+
+	asterisk, _ := w.NewSet(1, "voice", "asterisk", "sip") // nolint: errcheck
+	media, _ := w.NewSet(2, "voice", "media", "sip")       // nolint: errcheck
+	proxy, _ := w.NewSet(3, "edge", "proxy", "sip")        // nolint: errcheck
+
+	if err := w.Start(ctx); err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+
+	waitFor(t, "the watcher to sync", w.HasSynced)
+
+	selectors := listSelectors(kc, "endpointslices")
+
+	if want := "kubernetes.io/service-name in (asterisk,media)"; selectors["voice"] != want {
+		t.Errorf("expected the voice namespace to be listed with %q, got %q", want, selectors["voice"])
+	}
+
+	if want := "kubernetes.io/service-name in (proxy)"; selectors["edge"] != want {
+		t.Errorf("expected the edge namespace to be listed with %q, got %q", want, selectors["edge"])
+	}
+
+	// The EndpointSlices of other Services are not held by the informer.
+	w.mu.RLock()
+	keys := w.informers["voice"].GetStore().ListKeys()
+	w.mu.RUnlock()
+
+	sort.Strings(keys)
+
+	if strings.Join(keys, ",") != "voice/asterisk,voice/media" {
+		t.Errorf("expected only the selected EndpointSlices to be held, got %v", keys)
+	}
+
+	waitFor(t, "the initial members", func() bool {
+		return memberList(asterisk) == "10.0.0.1:5060" && memberList(media) == "10.0.1.1:5060" && memberList(proxy) == "10.1.0.1:5060"
+	})
+
+	// Each of the selected sets, in either namespace, keeps updating.
+	for _, slice := range []*discoveryv1.EndpointSlice{
+		testSlice("voice", "media", "media", true, "10.0.1.1", "10.0.1.2"),
+		testSlice("edge", "proxy", "proxy", false, "10.1.0.1"),
+	} {
+		if _, err := kc.DiscoveryV1().EndpointSlices(slice.Namespace).Update(ctx, slice, metav1.UpdateOptions{}); err != nil {
+			t.Fatalf("failed to update EndpointSlice: %v", err)
+		}
+	}
+
+	waitFor(t, "the updates of the selected sets", func() bool {
+		return memberList(media) == "10.0.1.1:5060,10.0.1.2:5060" && memberList(proxy) == "10.1.0.1:5060!"
+	})
+
+	if memberList(asterisk) != "10.0.0.1:5060" {
+		t.Errorf("expected the asterisk set to be unchanged, got %s", memberList(asterisk))
+	}
+}
+
+func TestKubernetesWatcherLegacy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	endpoints := func(addrs ...string) *v1.Endpoints {
+		ep := &v1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "voice", Name: "asterisk"},
+			Subsets: []v1.EndpointSubset{{
+				Ports: []v1.EndpointPort{{Name: "sip", Port: 5060}},
+			}},
+		}
+
+		for _, addr := range addrs {
+			ep.Subsets[0].Addresses = append(ep.Subsets[0].Addresses, v1.EndpointAddress{IP: addr})
+		}
+
+		return ep
+	}
+
+	kc := fake.NewSimpleClientset(endpoints("10.0.0.1"))
+
+	// The Service selector does not apply to legacy Endpoints, which are named after their Service.
+	w := &KubernetesWatcher{Client: kc, Legacy: true, SelectServices: true}
+
+	asterisk, err := w.NewSet(1, "voice", "asterisk", "sip")
+	if err != nil {
+		t.Fatalf("failed to create set: %v", err)
+	}
+
+	if err = w.Start(ctx); err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+
+	waitFor(t, "the watcher to sync", w.HasSynced)
+
+	if selectors := listSelectors(kc, "endpoints"); len(selectors) != 1 || selectors["voice"] != "" {
+		t.Errorf("expected an unfiltered list of the voice namespace only, got %v", selectors)
+	}
+
+	waitFor(t, "the initial members", func() bool {
+		return memberList(asterisk) == "10.0.0.1:5060"
+	})
+
+	if _, err = kc.CoreV1().Endpoints("voice").Update(ctx, endpoints("10.0.0.1", "10.0.0.2"), metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update Endpoints: %v", err)
+	}
+
+	waitFor(t, "the update", func() bool {
+		return memberList(asterisk) == "10.0.0.1:5060,10.0.0.2:5060"
+	})
+
+	if err = kc.CoreV1().Endpoints("voice").Delete(ctx, "asterisk", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("failed to delete Endpoints: %v", err)
+	}
+
+	waitFor(t, "the deletion", func() bool {
+		return len(asterisk.State().Endpoints) == 0
+	})
+}